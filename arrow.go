@@ -0,0 +1,643 @@
+package athena
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/apache/arrow/go/v15/arrow"
+	"github.com/apache/arrow/go/v15/arrow/array"
+	"github.com/apache/arrow/go/v15/arrow/decimal128"
+	"github.com/apache/arrow/go/v15/arrow/memory"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/aws/aws-sdk-go/service/athena/athenaiface"
+)
+
+// ArrowOptions configures QueryArrow.
+type ArrowOptions struct {
+	// BatchSize is the number of rows per record. Zero emits a record per
+	// result page.
+	BatchSize int
+	// Allocator allocates the record buffers. Defaults to
+	// memory.DefaultAllocator.
+	Allocator memory.Allocator
+}
+
+// QueryArrow runs query on db and returns a reader of its result as Arrow
+// records, built from the raw result pages without going through
+// driver.Value. The reader must be released.
+//
+// The schema is built from the column metadata of the result. Decimals map to
+// decimal128, timestamps to timestamps in the unit of their precision,
+// milliseconds by default, zoneless ones holding the wall clock time and
+// those with a time zone the UTC instant, intervals to
+// month intervals and durations. Arrays, maps and rows map to lists, maps and
+// structs when their column type names their element types, and to strings
+// holding Athena's rendering otherwise.
+//
+// db must have been opened with this driver.
+func QueryArrow(ctx context.Context, db *sql.DB, query string, args []interface{}, opts ArrowOptions) (*ArrowReader, error) {
+	if opts.BatchSize < 0 {
+		return nil, fmt.Errorf("batch size must not be negative, got %d", opts.BatchSize)
+	}
+	if opts.Allocator == nil {
+		opts.Allocator = memory.DefaultAllocator
+	}
+	namedArgs := make([]driver.NamedValue, 0, len(args))
+	for i, arg := range args {
+		namedArgs = append(namedArgs, driver.NamedValue{Ordinal: i + 1, Value: arg})
+	}
+
+	var r *ArrowReader
+	err := withConn(ctx, db, func(c *conn) error {
+		queryID, err := c.startQuery(query, namedArgs)
+		if err != nil {
+			return err
+		}
+		exec, err := c.waitOnQuery(ctx, queryID)
+		if err != nil {
+			return err
+		}
+		r, err = newArrowReader(ctx, arrowReaderConfig{
+			Athena:     c.athena,
+			QueryID:    queryID,
+			SkipHeader: hasHeaderRow(exec),
+			PageSize:   c.pageSize,
+			Retry:      c.retry,
+			Options:    opts,
+		})
+		return err
+	})
+	return r, err
+}
+
+type arrowReaderConfig struct {
+	Athena     athenaiface.AthenaAPI
+	QueryID    string
+	SkipHeader bool
+	PageSize   int64
+	Retry      retryPolicy
+	Options    ArrowOptions
+}
+
+// ArrowReader reads a query result as Arrow records. It implements
+// array.RecordReader.
+type ArrowReader struct {
+	refs      int64
+	ctx       context.Context
+	athena    athenaiface.AthenaAPI
+	queryID   string
+	pageSize  int64
+	retry     retryPolicy
+	batchSize int
+
+	schema  *arrow.Schema
+	types   []typeSignature
+	builder *array.RecordBuilder
+
+	page      []*athena.Row
+	nextToken *string
+	done      bool
+	rec       arrow.Record
+	err       error
+}
+
+var _ array.RecordReader = (*ArrowReader)(nil)
+
+func newArrowReader(ctx context.Context, cfg arrowReaderConfig) (*ArrowReader, error) {
+	if cfg.PageSize == 0 {
+		cfg.PageSize = maxResultCnt
+	}
+	r := &ArrowReader{
+		refs:      1,
+		ctx:       ctx,
+		athena:    cfg.Athena,
+		queryID:   cfg.QueryID,
+		pageSize:  cfg.PageSize,
+		retry:     cfg.Retry,
+		batchSize: cfg.Options.BatchSize,
+	}
+
+	out, err := r.fetchPage(nil)
+	if err != nil {
+		return nil, err
+	}
+	if out.ResultSet == nil || out.ResultSet.ResultSetMetadata == nil {
+		return nil, fmt.Errorf("query %s has no result metadata", cfg.QueryID)
+	}
+	columns := out.ResultSet.ResultSetMetadata.ColumnInfo
+	fields := make([]arrow.Field, len(columns))
+	r.types = make([]typeSignature, len(columns))
+	for i, colInfo := range columns {
+		sig, err := parseTypeSignature(aws.StringValue(colInfo.Type))
+		if err != nil {
+			return nil, err
+		}
+		if sig.name == "decimal" && len(sig.args) == 0 && colInfo.Precision != nil {
+			sig.args = []int64{aws.Int64Value(colInfo.Precision), aws.Int64Value(colInfo.Scale)}
+		}
+		r.types[i] = sig
+		fields[i] = arrow.Field{
+			Name:     aws.StringValue(colInfo.Name),
+			Type:     sig.arrowType(),
+			Nullable: aws.StringValue(colInfo.Nullable) != athena.ColumnNullableNotNull,
+		}
+	}
+	r.schema = arrow.NewSchema(fields, nil)
+	r.builder = array.NewRecordBuilder(cfg.Options.Allocator, r.schema)
+
+	r.setPage(out)
+	if cfg.SkipHeader && len(r.page) > 0 {
+		r.page = r.page[1:]
+	}
+	return r, nil
+}
+
+func (r *ArrowReader) fetchPage(token *string) (*athena.GetQueryResultsOutput, error) {
+	var out *athena.GetQueryResultsOutput
+	err := r.retry.do(r.ctx, "GetQueryResults", r.queryID, func() error {
+		var err error
+		out, err = r.athena.GetQueryResultsWithContext(r.ctx, &athena.GetQueryResultsInput{
+			QueryExecutionId: aws.String(r.queryID),
+			NextToken:        token,
+			MaxResults:       aws.Int64(r.pageSize),
+		})
+		return err
+	})
+	return out, err
+}
+
+func (r *ArrowReader) setPage(out *athena.GetQueryResultsOutput) {
+	r.page = nil
+	if out.ResultSet != nil {
+		r.page = out.ResultSet.Rows
+	}
+	r.nextToken = out.NextToken
+	if r.nextToken != nil && *r.nextToken == "" {
+		r.nextToken = nil
+	}
+}
+
+// Schema returns the schema of the records.
+func (r *ArrowReader) Schema() *arrow.Schema {
+	return r.schema
+}
+
+// Next reads the next record, returning false once the result is exhausted
+// or reading it failed, see Err.
+func (r *ArrowReader) Next() bool {
+	if r.rec != nil {
+		r.rec.Release()
+		r.rec = nil
+	}
+	if r.done || r.err != nil {
+		return false
+	}
+
+	rowCnt := 0
+	for r.batchSize == 0 || rowCnt < r.batchSize {
+		if len(r.page) == 0 {
+			if r.nextToken == nil {
+				r.done = true
+				break
+			}
+			if r.batchSize == 0 && rowCnt > 0 {
+				// a record per page
+				break
+			}
+			out, err := r.fetchPage(r.nextToken)
+			if err != nil {
+				r.err = err
+				return false
+			}
+			r.setPage(out)
+			continue
+		}
+
+		row := r.page[0]
+		r.page = r.page[1:]
+		for i, sig := range r.types {
+			var val *string
+			if i < len(row.Data) {
+				val = row.Data[i].VarCharValue
+			}
+			if err := appendArrowValue(r.builder.Field(i), sig, val); err != nil {
+				r.err = fmt.Errorf("column %s: %w", r.schema.Field(i).Name, err)
+				return false
+			}
+		}
+		rowCnt++
+	}
+
+	if rowCnt == 0 {
+		return false
+	}
+	r.rec = r.builder.NewRecord()
+	return true
+}
+
+// Record returns the current record. It's only valid until the next call to
+// Next, call Retain to keep it longer.
+func (r *ArrowReader) Record() arrow.Record {
+	return r.rec
+}
+
+// Err returns the error that stopped Next, if any.
+func (r *ArrowReader) Err() error {
+	return r.err
+}
+
+// Retain increases the reference count of the reader.
+func (r *ArrowReader) Retain() {
+	atomic.AddInt64(&r.refs, 1)
+}
+
+// Release decreases the reference count of the reader, releasing its memory
+// once it reaches zero.
+func (r *ArrowReader) Release() {
+	if atomic.AddInt64(&r.refs, -1) != 0 {
+		return
+	}
+	if r.rec != nil {
+		r.rec.Release()
+		r.rec = nil
+	}
+	r.builder.Release()
+	r.done = true
+}
+
+// typeSignature is a parsed Athena column type, e.g.
+// `array(row(id integer, price decimal(10,2)))`.
+type typeSignature struct {
+	// name is the normalized type name, see normalizeType.
+	name string
+	// params are the element types of an array, the key and value types of
+	// a map or the field types of a row.
+	params []typeSignature
+	// fields are the field names of a row.
+	fields []string
+	// args are numeric parameters, e.g. the precision and scale of a decimal.
+	args []int64
+}
+
+func parseTypeSignature(s string) (typeSignature, error) {
+	s = strings.TrimSpace(s)
+	sig := typeSignature{name: normalizeType(s)}
+
+	open := strings.IndexByte(s, '(')
+	if open < 0 {
+		return sig, nil
+	}
+	end := strings.LastIndexByte(s, ')')
+	if end < open {
+		return sig, fmt.Errorf("invalid type %s", s)
+	}
+	params := splitTopLevel(s[open+1:end], ",")
+
+	switch sig.name {
+	case "array", "map":
+		for _, param := range params {
+			p, err := parseTypeSignature(param)
+			if err != nil {
+				return sig, err
+			}
+			sig.params = append(sig.params, p)
+		}
+		if (sig.name == "array" && len(sig.params) != 1) || (sig.name == "map" && len(sig.params) != 2) {
+			return sig, fmt.Errorf("invalid type %s", s)
+		}
+	case "row":
+		for i, param := range params {
+			param = strings.TrimSpace(param)
+			name, typ, ok := strings.Cut(param, " ")
+			if !ok || strings.HasPrefix(strings.ToLower(typ), "with ") {
+				// unnamed field
+				name, typ = fmt.Sprintf("field%d", i), param
+			}
+			p, err := parseTypeSignature(typ)
+			if err != nil {
+				return sig, err
+			}
+			sig.fields = append(sig.fields, strings.Trim(name, `"`))
+			sig.params = append(sig.params, p)
+		}
+	default:
+		for _, param := range params {
+			if n, err := strconv.ParseInt(strings.TrimSpace(param), 10, 64); err == nil {
+				sig.args = append(sig.args, n)
+			}
+		}
+	}
+	return sig, nil
+}
+
+// splitTopLevel splits s at the separators that aren't nested in brackets.
+func splitTopLevel(s, sep string) []string {
+	var (
+		parts []string
+		depth int
+		start int
+	)
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '(', '[', '{':
+			depth++
+		case ')', ']', '}':
+			depth--
+		default:
+			if depth == 0 && strings.HasPrefix(s[i:], sep) {
+				parts = append(parts, s[start:i])
+				start = i + len(sep)
+				i += len(sep) - 1
+			}
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// arrowType returns the Arrow type values of the signature are read into.
+func (sig typeSignature) arrowType() arrow.DataType {
+	switch sig.name {
+	case "boolean":
+		return arrow.FixedWidthTypes.Boolean
+	case "tinyint":
+		return arrow.PrimitiveTypes.Int8
+	case "smallint":
+		return arrow.PrimitiveTypes.Int16
+	case "integer", "int":
+		return arrow.PrimitiveTypes.Int32
+	case "bigint":
+		return arrow.PrimitiveTypes.Int64
+	case "float", "real":
+		return arrow.PrimitiveTypes.Float32
+	case "double":
+		return arrow.PrimitiveTypes.Float64
+	case "decimal":
+		precision, scale := int32(38), int32(0)
+		if len(sig.args) > 0 {
+			precision = int32(sig.args[0])
+		}
+		if len(sig.args) > 1 {
+			scale = int32(sig.args[1])
+		}
+		return &arrow.Decimal128Type{Precision: precision, Scale: scale}
+	case "varbinary":
+		return arrow.BinaryTypes.Binary
+	case "date":
+		return arrow.FixedWidthTypes.Date32
+	case "timestamp":
+		return &arrow.TimestampType{Unit: sig.timestampUnit()}
+	case "timestamp with time zone":
+		return &arrow.TimestampType{Unit: sig.timestampUnit(), TimeZone: "UTC"}
+	case "time":
+		return arrow.FixedWidthTypes.Time64ns
+	case "interval year to month":
+		return arrow.FixedWidthTypes.MonthInterval
+	case "interval day to second":
+		return arrow.FixedWidthTypes.Duration_ms
+	case "array":
+		if len(sig.params) == 1 {
+			return arrow.ListOf(sig.params[0].arrowType())
+		}
+	case "map":
+		if len(sig.params) == 2 {
+			return arrow.MapOf(sig.params[0].arrowType(), sig.params[1].arrowType())
+		}
+	case "row":
+		if len(sig.params) > 0 {
+			fields := make([]arrow.Field, len(sig.params))
+			for i, p := range sig.params {
+				fields[i] = arrow.Field{Name: sig.fields[i], Type: p.arrowType(), Nullable: true}
+			}
+			return arrow.StructOf(fields...)
+		}
+	}
+	return arrow.BinaryTypes.String
+}
+
+// timestampUnit returns the finest unit keeping the digits of the precision
+// of a timestamp signature. Timestamps without precision have milliseconds,
+// Athena's default.
+func (sig typeSignature) timestampUnit() arrow.TimeUnit {
+	switch {
+	case len(sig.args) == 0 || sig.args[0] <= 3:
+		return arrow.Millisecond
+	case sig.args[0] <= 6:
+		return arrow.Microsecond
+	default:
+		return arrow.Nanosecond
+	}
+}
+
+// appendArrowValue parses val, the rendering of a value of type sig in
+// Athena's results, and appends it to b, which was created for
+// sig.arrowType(). A nil val appends a null.
+func appendArrowValue(b array.Builder, sig typeSignature, val *string) error {
+	if val == nil {
+		b.AppendNull()
+		return nil
+	}
+	text := *val
+
+	switch b := b.(type) {
+	case *array.StringBuilder:
+		b.Append(text)
+	case *array.BooleanBuilder:
+		v, err := strconv.ParseBool(text)
+		if err != nil {
+			return err
+		}
+		b.Append(v)
+	case *array.Int8Builder:
+		v, err := strconv.ParseInt(text, 10, 8)
+		if err != nil {
+			return err
+		}
+		b.Append(int8(v))
+	case *array.Int16Builder:
+		v, err := strconv.ParseInt(text, 10, 16)
+		if err != nil {
+			return err
+		}
+		b.Append(int16(v))
+	case *array.Int32Builder:
+		v, err := strconv.ParseInt(text, 10, 32)
+		if err != nil {
+			return err
+		}
+		b.Append(int32(v))
+	case *array.Int64Builder:
+		v, err := strconv.ParseInt(text, 10, 64)
+		if err != nil {
+			return err
+		}
+		b.Append(v)
+	case *array.Float32Builder:
+		v, err := strconv.ParseFloat(text, 32)
+		if err != nil {
+			return err
+		}
+		b.Append(float32(v))
+	case *array.Float64Builder:
+		v, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return err
+		}
+		b.Append(v)
+	case *array.Decimal128Builder:
+		v, err := decimal128FromString(text, b.Type().(*arrow.Decimal128Type).Scale)
+		if err != nil {
+			return err
+		}
+		b.Append(v)
+	case *array.BinaryBuilder:
+		v, err := parseVarbinary(text)
+		if err != nil {
+			return err
+		}
+		b.Append(v)
+	case *array.Date32Builder:
+		t, err := time.Parse(DateLayout, text)
+		if err != nil {
+			return err
+		}
+		b.Append(arrow.Date32FromTime(t))
+	case *array.TimestampBuilder:
+		var (
+			t   time.Time
+			err error
+		)
+		if sig.name == "timestamp with time zone" {
+			t, err = parseWithTimeZone(TimestampLayout, text)
+		} else {
+			t, err = time.Parse(TimestampLayout, text)
+		}
+		if err != nil {
+			return err
+		}
+		switch b.Type().(*arrow.TimestampType).Unit {
+		case arrow.Microsecond:
+			b.Append(arrow.Timestamp(t.UnixMicro()))
+		case arrow.Nanosecond:
+			b.Append(arrow.Timestamp(t.UnixNano()))
+		default:
+			b.Append(arrow.Timestamp(t.UnixMilli()))
+		}
+	case *array.Time64Builder:
+		t, err := time.Parse(TimeLayout, text)
+		if err != nil {
+			return err
+		}
+		sinceMidnight := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute +
+			time.Duration(t.Second())*time.Second + time.Duration(t.Nanosecond())
+		b.Append(arrow.Time64(sinceMidnight))
+	case *array.MonthIntervalBuilder:
+		v, err := parseIntervalYearToMonth(text)
+		if err != nil {
+			return err
+		}
+		b.Append(arrow.MonthInterval(v))
+	case *array.DurationBuilder:
+		v, err := parseIntervalDayToSecond(text)
+		if err != nil {
+			return err
+		}
+		b.Append(arrow.Duration(v.Milliseconds()))
+	case *array.ListBuilder:
+		items, err := splitArrowContainer(text, '[', ']')
+		if err != nil {
+			return err
+		}
+		b.Append(true)
+		for _, item := range items {
+			if err := appendArrowValue(b.ValueBuilder(), sig.params[0], nullableText(item)); err != nil {
+				return err
+			}
+		}
+	case *array.MapBuilder:
+		entries, err := splitArrowContainer(text, '{', '}')
+		if err != nil {
+			return err
+		}
+		b.Append(true)
+		for _, entry := range entries {
+			kv := splitTopLevel(entry, "=")
+			if len(kv) < 2 {
+				return fmt.Errorf("cannot parse '%s' as map", text)
+			}
+			key, value := kv[0], strings.Join(kv[1:], "=")
+			if err := appendArrowValue(b.KeyBuilder(), sig.params[0], &key); err != nil {
+				return err
+			}
+			if err := appendArrowValue(b.ItemBuilder(), sig.params[1], nullableText(value)); err != nil {
+				return err
+			}
+		}
+	case *array.StructBuilder:
+		values, err := parseRowText(text)
+		if err != nil {
+			return err
+		}
+		b.Append(true)
+		for i, name := range sig.fields {
+			var field *string
+			if v, ok := values[name].(string); ok {
+				field = &v
+			}
+			if err := appendArrowValue(b.FieldBuilder(i), sig.params[i], field); err != nil {
+				return fmt.Errorf("row field %s: %w", name, err)
+			}
+		}
+	default:
+		return fmt.Errorf("unsupported arrow type %s", b.Type())
+	}
+	return nil
+}
+
+// splitArrowContainer splits the items of an array `[a, b]` or the entries of
+// a map `{k=v, k2=v2}`. As values aren't quoted, a varchar item containing
+// `, ` can't be told apart from two items.
+func splitArrowContainer(text string, open, close byte) ([]string, error) {
+	if len(text) < 2 || text[0] != open || text[len(text)-1] != close {
+		return nil, fmt.Errorf("cannot parse '%s' as %c%c", text, open, close)
+	}
+	inner := text[1 : len(text)-1]
+	if inner == "" {
+		return nil, nil
+	}
+	return splitTopLevel(inner, ", "), nil
+}
+
+func nullableText(text string) *string {
+	if text == "null" {
+		return nil
+	}
+	return &text
+}
+
+// decimal128FromString parses a decimal and rescales it to scale.
+func decimal128FromString(text string, scale int32) (decimal128.Num, error) {
+	d, err := ParseDecimal(text)
+	if err != nil {
+		return decimal128.Num{}, err
+	}
+	if d.Scale() > scale {
+		return decimal128.Num{}, fmt.Errorf("decimal %s has more than %d fractional digits", text, scale)
+	}
+	unscaled := d.Unscaled()
+	if d.Scale() < scale {
+		unscaled.Mul(unscaled, new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(scale-d.Scale())), nil))
+	}
+	if unscaled.BitLen() > 127 {
+		return decimal128.Num{}, errors.New("decimal overflows decimal128")
+	}
+	return decimal128.FromBigInt(unscaled), nil
+}
@@ -0,0 +1,157 @@
+package athena
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/apache/arrow/go/v15/arrow"
+	"github.com/apache/arrow/go/v15/arrow/array"
+	"github.com/apache/arrow/go/v15/arrow/decimal128"
+	"github.com/apache/arrow/go/v15/arrow/memory"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/vx416/go-athenav/athenamock"
+)
+
+func TestParseTypeSignature(t *testing.T) {
+	tests := map[string]arrow.DataType{
+		"varchar(10)":                 arrow.BinaryTypes.String,
+		"decimal(10, 2)":              &arrow.Decimal128Type{Precision: 10, Scale: 2},
+		"timestamp(3) with time zone": &arrow.TimestampType{Unit: arrow.Millisecond, TimeZone: "UTC"},
+		"timestamp":                   &arrow.TimestampType{Unit: arrow.Millisecond},
+		"timestamp(6)":                &arrow.TimestampType{Unit: arrow.Microsecond},
+		"timestamp(9) with time zone": &arrow.TimestampType{Unit: arrow.Nanosecond, TimeZone: "UTC"},
+		"array(bigint)":               arrow.ListOf(arrow.PrimitiveTypes.Int64),
+		"array":                       arrow.BinaryTypes.String,
+		"map(varchar, array(double))": arrow.MapOf(arrow.BinaryTypes.String, arrow.ListOf(arrow.PrimitiveTypes.Float64)),
+		"row(id integer, price decimal(10,2))": arrow.StructOf(
+			arrow.Field{Name: "id", Type: arrow.PrimitiveTypes.Int32, Nullable: true},
+			arrow.Field{Name: "price", Type: &arrow.Decimal128Type{Precision: 10, Scale: 2}, Nullable: true},
+		),
+	}
+	for in, expected := range tests {
+		sig, err := parseTypeSignature(in)
+		require.NoError(t, err, in)
+		assert.True(t, arrow.TypeEqual(expected, sig.arrowType()), "%s: %s", in, sig.arrowType())
+	}
+}
+
+func TestQueryArrow(t *testing.T) {
+	columns := []*athena.ColumnInfo{
+		{Name: aws.String("id"), Type: aws.String("integer")},
+		{Name: aws.String("price"), Type: aws.String("decimal"), Precision: aws.Int64(10), Scale: aws.Int64(2)},
+		{Name: aws.String("at"), Type: aws.String("timestamp")},
+		{Name: aws.String("tags"), Type: aws.String("array(varchar)")},
+		{Name: aws.String("attrs"), Type: aws.String("map(varchar, integer)")},
+		{Name: aws.String("address"), Type: aws.String("row(city varchar, zip integer)")},
+	}
+	row := func(vals ...*string) *athena.Row {
+		r := &athena.Row{}
+		for _, val := range vals {
+			r.Data = append(r.Data, &athena.Datum{VarCharValue: val})
+		}
+		return r
+	}
+	s := aws.String
+
+	mockAPI := athenamock.AthenaAPI{}
+	mockAPI.On("StartQueryExecution", mock.Anything).Return(&athena.StartQueryExecutionOutput{QueryExecutionId: aws.String("arrow")}, nil)
+	mockAPI.On("GetQueryExecutionWithContext", mock.Anything, mock.Anything).Return(&athena.GetQueryExecutionOutput{QueryExecution: &athena.QueryExecution{
+		Status: &athena.QueryExecutionStatus{State: aws.String(athena.QueryExecutionStateSucceeded)},
+	}}, nil)
+	mockAPI.On("GetQueryResultsWithContext", mock.Anything, mock.Anything).Return(func(aws.Context, *athena.GetQueryResultsInput, ...request.Option) *athena.GetQueryResultsOutput {
+		return &athena.GetQueryResultsOutput{ResultSet: &athena.ResultSet{
+			ResultSetMetadata: &athena.ResultSetMetadata{ColumnInfo: columns},
+			Rows: []*athena.Row{
+				row(s("id"), s("price"), s("at"), s("tags"), s("attrs"), s("address")),
+				row(s("1"), s("10.5"), s("2024-01-02 03:04:05.123"), s("[a, b]"), s("{x=1, y=null}"), s("{city=Paris, zip=75001}")),
+				row(nil, nil, nil, s("[]"), nil, s("{city=null, zip=null}")),
+			},
+		}}
+	}, nil)
+	db := openMockDB(t, &mockAPI)
+
+	alloc := memory.NewCheckedAllocator(memory.NewGoAllocator())
+	defer alloc.AssertSize(t, 0)
+	r, err := QueryArrow(context.Background(), db, "SELECT * FROM t", nil, ArrowOptions{Allocator: alloc})
+	require.NoError(t, err)
+	defer r.Release()
+
+	require.True(t, r.Next())
+	rec := r.Record()
+	assert.Equal(t, int64(2), rec.NumRows())
+
+	ids := rec.Column(0).(*array.Int32)
+	assert.Equal(t, int32(1), ids.Value(0))
+	assert.True(t, ids.IsNull(1))
+	assert.Equal(t, decimal128.FromI64(1050), rec.Column(1).(*array.Decimal128).Value(0))
+	assert.Equal(t, time.Date(2024, 1, 2, 3, 4, 5, 123000000, time.UTC).UnixMilli(), int64(rec.Column(2).(*array.Timestamp).Value(0)))
+	assert.Equal(t, `["a","b"]`, rec.Column(3).(*array.List).ValueStr(0))
+	start, end := rec.Column(3).(*array.List).ValueOffsets(1)
+	assert.Equal(t, start, end)
+	attrs := rec.Column(4).(*array.Map)
+	assert.Equal(t, `["x" "y"]`, attrs.Keys().(*array.String).String())
+	assert.True(t, attrs.Items().IsNull(1))
+	address := rec.Column(5).(*array.Struct)
+	assert.Equal(t, "Paris", address.Field(0).(*array.String).Value(0))
+	assert.Equal(t, int32(75001), address.Field(1).(*array.Int32).Value(0))
+	assert.True(t, address.Field(0).IsNull(1))
+
+	assert.False(t, r.Next())
+	assert.NoError(t, r.Err())
+}
+
+func TestQueryArrow_TimestampPrecision(t *testing.T) {
+	mockAPI := athenamock.AthenaAPI{}
+	MockQuery(&mockAPI, []string{"at", "zoned"}, []string{"timestamp(6)", "timestamp(9) with time zone"},
+		[][]string{{"2024-01-02 03:04:05.123456", "2024-01-02 03:04:05.123456789 +01:00"}})
+
+	r, err := QueryArrow(context.Background(), openMockDB(t, &mockAPI), "SELECT * FROM t", nil, ArrowOptions{})
+	require.NoError(t, err)
+	defer r.Release()
+	require.True(t, r.Next())
+	rec := r.Record()
+
+	at := rec.Column(0).(*array.Timestamp)
+	assert.Equal(t, arrow.Microsecond, at.DataType().(*arrow.TimestampType).Unit)
+	assert.Equal(t, time.Date(2024, 1, 2, 3, 4, 5, 123456000, time.UTC).UnixMicro(), int64(at.Value(0)))
+	zoned := rec.Column(1).(*array.Timestamp)
+	assert.Equal(t, arrow.Nanosecond, zoned.DataType().(*arrow.TimestampType).Unit)
+	assert.Equal(t, time.Date(2024, 1, 2, 2, 4, 5, 123456789, time.UTC).UnixNano(), int64(zoned.Value(0)))
+}
+
+func TestArrowReader_Batches(t *testing.T) {
+	for _, test := range []struct {
+		batchSize int
+		expected  []int64
+	}{
+		{batchSize: 0, expected: []int64{1, 2, 2}},
+		{batchSize: 3, expected: []int64{3, 2}},
+		{batchSize: 10, expected: []int64{5}},
+	} {
+		r, err := newArrowReader(context.Background(), arrowReaderConfig{
+			Athena:     &pagingAthenaClient{rowCnt: 5},
+			QueryID:    "query-1",
+			SkipHeader: true,
+			PageSize:   2,
+			Options:    ArrowOptions{BatchSize: test.batchSize, Allocator: memory.DefaultAllocator},
+		})
+		require.NoError(t, err)
+
+		var sizes []int64
+		var values []int32
+		for r.Next() {
+			sizes = append(sizes, r.Record().NumRows())
+			values = append(values, r.Record().Column(0).(*array.Int32).Int32Values()...)
+		}
+		require.NoError(t, r.Err())
+		assert.Equal(t, test.expected, sizes, "batch size %d", test.batchSize)
+		assert.Equal(t, []int32{1, 2, 3, 4, 5}, values)
+		r.Release()
+	}
+}
@@ -0,0 +1,325 @@
+package athenamock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/spf13/cast"
+	"github.com/stretchr/testify/mock"
+	"github.com/vx416/go-athenav/internal/athenafmt"
+)
+
+// Expecter is an AthenaAPI mock asserting which queries run and with which
+// execution parameters, in the style of go-sqlmock:
+//
+//	mockAPI := athenamock.NewExpecter()
+//	mockAPI.ExpectQuery(`^SELECT name FROM users WHERE id = \?$`).
+//		WithArgs(42).
+//		WillReturnRows(athenamock.NewRows("name").AddRow("vic"))
+//	connector, err := athena.NewConnector(athena.Config{Athena: mockAPI})
+//	if err != nil {
+//		t.Fatal(err)
+//	}
+//	db := sql.OpenDB(connector)
+//	...
+//	if err := mockAPI.ExpectationsWereMet(); err != nil {
+//		t.Error(err)
+//	}
+//
+// Queries not matching the next expectation fail to start. Exec statements
+// are expected with ExpectQuery too, since Athena runs every statement as a
+// query.
+type Expecter struct {
+	AthenaAPI
+
+	mu         sync.Mutex
+	inOrder    bool
+	expected   []*ExpectedQuery
+	executions map[string]*ExpectedQuery
+}
+
+// NewExpecter returns an Expecter matching expectations in order.
+func NewExpecter() *Expecter {
+	e := &Expecter{inOrder: true, executions: map[string]*ExpectedQuery{}}
+	e.On("StartQueryExecution", mock.Anything).Return(e.startQuery).Maybe()
+	e.On("StartQueryExecutionWithContext", mock.Anything, mock.Anything).Return(
+		func(_ context.Context, input *athena.StartQueryExecutionInput, _ ...request.Option) (*athena.StartQueryExecutionOutput, error) {
+			return e.startQuery(input)
+		}).Maybe()
+	e.On("GetQueryExecutionWithContext", mock.Anything, mock.Anything).Return(
+		func(_ context.Context, input *athena.GetQueryExecutionInput, _ ...request.Option) (*athena.GetQueryExecutionOutput, error) {
+			if _, err := e.lookup(input.QueryExecutionId); err != nil {
+				return nil, err
+			}
+			return &athena.GetQueryExecutionOutput{QueryExecution: &athena.QueryExecution{
+				QueryExecutionId: input.QueryExecutionId,
+				StatementType:    aws.String(athena.StatementTypeDml),
+				Status:           &athena.QueryExecutionStatus{State: aws.String(athena.QueryExecutionStateSucceeded)},
+			}}, nil
+		}).Maybe()
+	e.On("GetQueryResultsWithContext", mock.Anything, mock.Anything).Return(
+		func(_ context.Context, input *athena.GetQueryResultsInput, _ ...request.Option) (*athena.GetQueryResultsOutput, error) {
+			expected, err := e.lookup(input.QueryExecutionId)
+			if err != nil {
+				return nil, err
+			}
+			return expected.rows.page(input.NextToken, aws.Int64Value(input.MaxResults))
+		}).Maybe()
+	e.On("StopQueryExecutionWithContext", mock.Anything, mock.Anything).Return(&athena.StopQueryExecutionOutput{}, nil).Maybe()
+	return e
+}
+
+// MatchExpectationsInOrder sets whether queries must run in the order they
+// are expected in. It is true by default.
+func (e *Expecter) MatchExpectationsInOrder(inOrder bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.inOrder = inOrder
+}
+
+// ExpectQuery expects a query matching the regular expression sqlRegex to
+// run. It panics if sqlRegex doesn't compile.
+func (e *Expecter) ExpectQuery(sqlRegex string) *ExpectedQuery {
+	expected := &ExpectedQuery{pattern: regexp.MustCompile(sqlRegex), rows: NewRows()}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.expected = append(e.expected, expected)
+	return expected
+}
+
+// ExpectationsWereMet returns an error listing the expected queries that
+// haven't run.
+func (e *Expecter) ExpectationsWereMet() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var errs []error
+	for _, expected := range e.expected {
+		if !expected.triggered {
+			errs = append(errs, fmt.Errorf("there is a remaining expectation which was not matched: %s", expected))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (e *Expecter) startQuery(input *athena.StartQueryExecutionInput) (*athena.StartQueryExecutionOutput, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	query := aws.StringValue(input.QueryString)
+	params := aws.StringValueSlice(input.ExecutionParameters)
+	var next *ExpectedQuery
+	for _, expected := range e.expected {
+		if expected.triggered {
+			continue
+		}
+		if expected.matches(query, params) {
+			next = expected
+			break
+		}
+		if e.inOrder {
+			return nil, fmt.Errorf("call to query %q with args %q was not expected, next expectation is: %s", query, params, expected)
+		}
+	}
+	if next == nil {
+		return nil, fmt.Errorf("call to query %q with args %q was not expected", query, params)
+	}
+
+	next.triggered = true
+	if next.err != nil {
+		return nil, next.err
+	}
+	queryID := "expected-query-" + strconv.Itoa(len(e.executions)+1)
+	e.executions[queryID] = next
+	return &athena.StartQueryExecutionOutput{QueryExecutionId: aws.String(queryID)}, nil
+}
+
+func (e *Expecter) lookup(queryID *string) (*ExpectedQuery, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	expected, ok := e.executions[aws.StringValue(queryID)]
+	if !ok {
+		return nil, awserr.New(athena.ErrCodeInvalidRequestException,
+			fmt.Sprintf("QueryExecution %s was not found", aws.StringValue(queryID)), nil)
+	}
+	return expected, nil
+}
+
+// ExpectedQuery is a query expected to run on an Expecter.
+type ExpectedQuery struct {
+	pattern   *regexp.Regexp
+	args      []interface{}
+	hasArgs   bool
+	rows      *Rows
+	err       error
+	triggered bool
+}
+
+// Argument matches an execution parameter of a query.
+type Argument interface {
+	Match(param string) bool
+}
+
+type anyArgument struct{}
+
+func (anyArgument) Match(string) bool {
+	return true
+}
+
+// AnyArg matches any execution parameter.
+func AnyArg() Argument {
+	return anyArgument{}
+}
+
+// WithArgs expects the query to run with args as execution parameters. Args
+// are Arguments or values, which match parameters the driver formats them
+// as, e.g. 42 matches "42".
+func (q *ExpectedQuery) WithArgs(args ...interface{}) *ExpectedQuery {
+	q.args = args
+	q.hasArgs = true
+	return q
+}
+
+// WillReturnRows makes the query return rows.
+func (q *ExpectedQuery) WillReturnRows(rows *Rows) *ExpectedQuery {
+	q.rows = rows
+	return q
+}
+
+// WillReturnError makes starting the query fail with err.
+func (q *ExpectedQuery) WillReturnError(err error) *ExpectedQuery {
+	q.err = err
+	return q
+}
+
+func (q *ExpectedQuery) matches(query string, params []string) bool {
+	if !q.pattern.MatchString(query) {
+		return false
+	}
+	if !q.hasArgs {
+		return true
+	}
+	if len(q.args) != len(params) {
+		return false
+	}
+	for i, arg := range q.args {
+		if matcher, ok := arg.(Argument); ok {
+			if !matcher.Match(params[i]) {
+				return false
+			}
+			continue
+		}
+		param, err := cast.ToStringE(arg)
+		if err != nil || param != params[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (q *ExpectedQuery) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "ExpectedQuery => expecting query matching %q", q.pattern)
+	if q.hasArgs {
+		fmt.Fprintf(&b, " with args %v", q.args)
+	}
+	return b.String()
+}
+
+// maxResults is the most rows Athena returns per GetQueryResults call.
+const maxResults = 1000
+
+// Rows is the result of an expected query.
+type Rows struct {
+	columns []string
+	types   []string
+	values  [][]*string
+}
+
+// NewRows returns an empty result with columns, which are varchar unless
+// set otherwise with ColumnTypes.
+func NewRows(columns ...string) *Rows {
+	types := make([]string, len(columns))
+	for i := range types {
+		types[i] = "varchar"
+	}
+	return &Rows{columns: columns, types: types}
+}
+
+// ColumnTypes sets the Athena types of the columns, e.g. "integer".
+func (r *Rows) ColumnTypes(types ...string) *Rows {
+	copy(r.types, types)
+	return r
+}
+
+// AddRow adds a row with values, one per column. nil is NULL and other values
+// are formatted as Athena renders values of the column type, so set
+// ColumnTypes before adding rows. It panics if the number of values doesn't
+// match the number of columns.
+func (r *Rows) AddRow(values ...interface{}) *Rows {
+	if len(values) != len(r.columns) {
+		panic(fmt.Sprintf("athenamock: row has %d values for %d columns", len(values), len(r.columns)))
+	}
+	row := make([]*string, len(values))
+	for i, val := range values {
+		text, err := athenafmt.Format(r.types[i], val)
+		if err != nil {
+			text = aws.String(fmt.Sprint(val))
+		}
+		row[i] = text
+	}
+	r.values = append(r.values, row)
+	return r
+}
+
+// page returns the rows from token on, preceded by the header row on the
+// first page.
+func (r *Rows) page(token *string, size int64) (*athena.GetQueryResultsOutput, error) {
+	rows := make([]*athena.Row, 0, len(r.values)+1)
+	header := &athena.Row{}
+	for _, column := range r.columns {
+		header.Data = append(header.Data, &athena.Datum{VarCharValue: aws.String(column)})
+	}
+	rows = append(rows, header)
+	for _, values := range r.values {
+		row := &athena.Row{}
+		for _, val := range values {
+			row.Data = append(row.Data, &athena.Datum{VarCharValue: val})
+		}
+		rows = append(rows, row)
+	}
+
+	start := 0
+	if token != nil {
+		var err error
+		if start, err = strconv.Atoi(*token); err != nil || start < 0 || start > len(rows) {
+			return nil, awserr.New(athena.ErrCodeInvalidRequestException, "invalid NextToken", nil)
+		}
+	}
+	if size <= 0 || size > maxResults {
+		size = maxResults
+	}
+	end := min(start+int(size), len(rows))
+
+	columns := make([]*athena.ColumnInfo, len(r.columns))
+	for i, column := range r.columns {
+		columns[i] = &athena.ColumnInfo{Name: aws.String(column), Label: aws.String(column), Type: aws.String(r.types[i])}
+	}
+	out := &athena.GetQueryResultsOutput{ResultSet: &athena.ResultSet{
+		ResultSetMetadata: &athena.ResultSetMetadata{ColumnInfo: columns},
+		Rows:              rows[start:end],
+	}}
+	if end < len(rows) {
+		out.NextToken = aws.String(strconv.Itoa(end))
+	}
+	return out, nil
+}
@@ -0,0 +1,114 @@
+package athenamock_test
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	athena "github.com/vx416/go-athenav"
+	"github.com/vx416/go-athenav/athenamock"
+)
+
+func openDB(t *testing.T, mockAPI *athenamock.Expecter) *sql.DB {
+	connector, err := athena.NewConnector(athena.Config{
+		Athena:             mockAPI,
+		PollFrequency:      time.Millisecond,
+		PollRetryIncrement: time.Millisecond,
+		MaxRetryDuration:   time.Millisecond,
+	})
+	require.NoError(t, err)
+	db := sql.OpenDB(connector)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestExpecter(t *testing.T) {
+	mockAPI := athenamock.NewExpecter()
+	mockAPI.ExpectQuery(`^SELECT id, name FROM users WHERE age > \? AND name <> \?$`).
+		WithArgs(18, athenamock.AnyArg()).
+		WillReturnRows(athenamock.NewRows("id", "name").ColumnTypes("integer").
+			AddRow(1, "vic").
+			AddRow(2, nil))
+	mockAPI.ExpectQuery(`^INSERT INTO users`).WithArgs("bob")
+	db := openDB(t, mockAPI)
+
+	rows, err := db.Query("SELECT id, name FROM users WHERE age > ? AND name <> ?", 18, "root")
+	require.NoError(t, err)
+	var (
+		ids   []int
+		names []*string
+	)
+	for rows.Next() {
+		var id int
+		var name *string
+		require.NoError(t, rows.Scan(&id, &name))
+		ids = append(ids, id)
+		names = append(names, name)
+	}
+	require.NoError(t, rows.Err())
+	assert.Equal(t, []int{1, 2}, ids)
+	require.Len(t, names, 2)
+	assert.Equal(t, "vic", *names[0])
+	assert.Nil(t, names[1])
+
+	assert.EqualError(t, mockAPI.ExpectationsWereMet(),
+		"there is a remaining expectation which was not matched: ExpectedQuery => expecting query matching \"^INSERT INTO users\" with args [bob]")
+
+	_, err = db.Exec("INSERT INTO users (name) VALUES (?)", "bob")
+	require.NoError(t, err)
+	assert.NoError(t, mockAPI.ExpectationsWereMet())
+
+	_, err = db.Exec("DELETE FROM users")
+	assert.ErrorContains(t, err, `call to query "DELETE FROM users" with args [] was not expected`)
+}
+
+func TestExpecter_Order(t *testing.T) {
+	mockAPI := athenamock.NewExpecter()
+	mockAPI.ExpectQuery(`^SELECT 1$`)
+	mockAPI.ExpectQuery(`^SELECT 2$`).WillReturnError(errors.New("too many queries"))
+	db := openDB(t, mockAPI)
+
+	_, err := db.Exec("SELECT 2")
+	assert.ErrorContains(t, err, `next expectation is: ExpectedQuery => expecting query matching "^SELECT 1$"`)
+
+	mockAPI.MatchExpectationsInOrder(false)
+	_, err = db.Exec("SELECT 2")
+	assert.EqualError(t, err, "too many queries")
+	_, err = db.Exec("SELECT 1")
+	assert.NoError(t, err)
+	assert.NoError(t, mockAPI.ExpectationsWereMet())
+}
+
+func TestExpecter_WithArgsMismatch(t *testing.T) {
+	mockAPI := athenamock.NewExpecter()
+	mockAPI.ExpectQuery(`^SELECT \?$`).WithArgs(1)
+	db := openDB(t, mockAPI)
+
+	_, err := db.Exec("SELECT ?", 2)
+	assert.ErrorContains(t, err, `call to query "SELECT ?" with args ["2"] was not expected`)
+	assert.Error(t, mockAPI.ExpectationsWereMet())
+}
+
+func TestRows_AddRowTypes(t *testing.T) {
+	at := time.Date(2024, 1, 2, 3, 4, 5, 0, time.FixedZone("", 5*3600+30*60))
+	mockAPI := athenamock.NewExpecter()
+	mockAPI.ExpectQuery(`^SELECT`).
+		WillReturnRows(athenamock.NewRows("ts", "d", "bin", "r").
+			ColumnTypes("timestamp(3) with time zone", "date", "varbinary", "real").
+			AddRow(at, at, []byte("hi"), float32(0.1)))
+	db := openDB(t, mockAPI)
+
+	var (
+		ts, d time.Time
+		bin   []byte
+		r     float32
+	)
+	require.NoError(t, db.QueryRow("SELECT ts, d, bin, r FROM t").Scan(&ts, &d, &bin, &r))
+	assert.True(t, at.Equal(ts), ts)
+	assert.Equal(t, "2024-01-02", d.Format("2006-01-02"))
+	assert.Equal(t, []byte("hi"), bin)
+	assert.Equal(t, float32(0.1), r)
+}
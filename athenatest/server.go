@@ -0,0 +1,256 @@
+// Package athenatest provides a local Athena endpoint for end-to-end tests
+// of the driver, including request signing, the Athena JSON protocol and
+// session setup.
+package athenatest
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/private/protocol/json/jsonutil"
+	awsathena "github.com/aws/aws-sdk-go/service/athena"
+	athena "github.com/vx416/go-athenav"
+)
+
+const (
+	// Region is the AWS region clients of a Server use.
+	Region = "us-east-1"
+	// Database is the database of the DSN and Config of a Server.
+	Database = "default"
+	// OutputLocation is the output location of the DSN and Config of a
+	// Server.
+	OutputLocation = "s3://athenatest-results/"
+
+	accessKey = "athenatest"
+	secretKey = "athenatest"
+)
+
+// Server is a local HTTP endpoint speaking the Athena JSON 1.1 protocol for
+// the operations the driver uses, backed by a FakeAthena, and the S3 REST
+// protocol for reading and writing objects. Reading the CSV file of a query
+// result from S3 returns the result of the query.
+type Server struct {
+	*httptest.Server
+
+	// Athena runs the queries sent to the server. Register results on it.
+	Athena *athena.FakeAthena
+
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+// NewServer starts a Server. Close it when done.
+func NewServer() *Server {
+	s := &Server{
+		Athena:  athena.NewFakeAthena(),
+		objects: make(map[string][]byte),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// DSN returns a data source name connecting to the server, to be used with
+// sql.Open("athena", dsn).
+func (s *Server) DSN() string {
+	return url.Values{
+		"db":                    {Database},
+		"output_location":       {OutputLocation},
+		"region":                {Region},
+		"aws_access_key":        {accessKey},
+		"aws_access_key_secret": {secretKey},
+		"endpoint":              {s.URL},
+		"poll_frequency":        {"10ms"},
+	}.Encode()
+}
+
+// Config returns a Config connecting to the server, to be used with
+// athena.Open.
+func (s *Server) Config() athena.Config {
+	return athena.Config{
+		Session:            session.Must(session.NewSession(s.AWSConfig())),
+		Database:           Database,
+		OutputLocation:     OutputLocation,
+		PollFrequency:      10 * time.Millisecond,
+		PollRetryIncrement: 10 * time.Millisecond,
+		MaxRetryDuration:   10 * time.Millisecond,
+	}
+}
+
+// AWSConfig returns the AWS config of clients of the server.
+func (s *Server) AWSConfig() *aws.Config {
+	return &aws.Config{
+		Region:           aws.String(Region),
+		Endpoint:         aws.String(s.URL),
+		Credentials:      credentials.NewStaticCredentials(accessKey, secretKey, ""),
+		S3ForcePathStyle: aws.Bool(true),
+	}
+}
+
+// PutObject stores an S3 object.
+func (s *Server) PutObject(bucket, key string, body []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[bucket+"/"+key] = body
+}
+
+// Object returns an S3 object stored with PutObject or uploaded to the
+// server.
+func (s *Server) Object(bucket, key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	body, ok := s.objects[bucket+"/"+key]
+	return body, ok
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 ") {
+		http.Error(w, "request is not signed", http.StatusForbidden)
+		return
+	}
+	if target := r.Header.Get("X-Amz-Target"); target != "" {
+		s.serveAthena(w, r, strings.TrimPrefix(target, "AmazonAthena."))
+		return
+	}
+	s.serveS3(w, r)
+}
+
+func (s *Server) serveAthena(w http.ResponseWriter, r *http.Request, operation string) {
+	var (
+		out interface{}
+		err error
+	)
+	switch operation {
+	case "StartQueryExecution":
+		var input awsathena.StartQueryExecutionInput
+		if err = jsonutil.UnmarshalJSON(&input, r.Body); err == nil {
+			out, err = s.Athena.StartQueryExecution(&input)
+		}
+	case "GetQueryExecution":
+		var input awsathena.GetQueryExecutionInput
+		if err = jsonutil.UnmarshalJSON(&input, r.Body); err == nil {
+			out, err = s.Athena.GetQueryExecution(&input)
+		}
+	case "GetQueryResults":
+		var input awsathena.GetQueryResultsInput
+		if err = jsonutil.UnmarshalJSON(&input, r.Body); err == nil {
+			out, err = s.Athena.GetQueryResults(&input)
+		}
+	case "StopQueryExecution":
+		var input awsathena.StopQueryExecutionInput
+		if err = jsonutil.UnmarshalJSON(&input, r.Body); err == nil {
+			out, err = s.Athena.StopQueryExecution(&input)
+		}
+	default:
+		err = awserr.New("UnknownOperationException", "athenatest doesn't support "+operation, nil)
+	}
+
+	var body []byte
+	if err == nil {
+		body, err = jsonutil.BuildJSON(out)
+	}
+	w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+	if err != nil {
+		code, status := awsathena.ErrCodeInternalServerException, http.StatusInternalServerError
+		if aerr, ok := err.(awserr.Error); ok {
+			code, status = aerr.Code(), http.StatusBadRequest
+		}
+		w.WriteHeader(status)
+		fmt.Fprintf(w, `{"__type":%q,"message":%q}`, code, errorMessage(err))
+		return
+	}
+	w.Write(body)
+}
+
+func errorMessage(err error) string {
+	if aerr, ok := err.(awserr.Error); ok {
+		return aerr.Message()
+	}
+	return err.Error()
+}
+
+func (s *Server) serveS3(w http.ResponseWriter, r *http.Request) {
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	switch r.Method {
+	case http.MethodPut:
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeS3Error(w, http.StatusBadRequest, "IncompleteBody", err.Error())
+			return
+		}
+		s.PutObject(bucket, key, body)
+	case http.MethodGet, http.MethodHead:
+		body, ok := s.Object(bucket, key)
+		if !ok {
+			body, ok = s.resultFile("s3://" + bucket + "/" + key)
+		}
+		if !ok {
+			writeS3Error(w, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+			return
+		}
+		w.Header().Set("Content-Length", fmt.Sprint(len(body)))
+		if r.Method == http.MethodGet {
+			w.Write(body)
+		}
+	default:
+		writeS3Error(w, http.StatusMethodNotAllowed, "MethodNotAllowed", "athenatest doesn't support "+r.Method)
+	}
+}
+
+func writeS3Error(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	xml.NewEncoder(w).Encode(struct {
+		XMLName xml.Name `xml:"Error"`
+		Code    string
+		Message string
+	}{Code: code, Message: message})
+}
+
+// resultFile renders the CSV file Athena writes to location for the result
+// of a succeeded query: every non-NULL value is quoted and NULLs are left
+// empty.
+func (s *Server) resultFile(location string) ([]byte, bool) {
+	for _, q := range s.Athena.Queries() {
+		if q.State != awsathena.QueryExecutionStateSucceeded || q.OutputLocation == "" ||
+			strings.TrimSuffix(q.OutputLocation, "/")+"/"+q.ID+".csv" != location {
+			continue
+		}
+
+		var buf bytes.Buffer
+		input := &awsathena.GetQueryResultsInput{QueryExecutionId: aws.String(q.ID)}
+		for {
+			out, err := s.Athena.GetQueryResults(input)
+			if err != nil {
+				return nil, false
+			}
+			for _, row := range out.ResultSet.Rows {
+				for i, datum := range row.Data {
+					if i > 0 {
+						buf.WriteByte(',')
+					}
+					if datum.VarCharValue != nil {
+						buf.WriteString(`"` + strings.ReplaceAll(*datum.VarCharValue, `"`, `""`) + `"`)
+					}
+				}
+				buf.WriteByte('\n')
+			}
+			if out.NextToken == nil {
+				return buf.Bytes(), true
+			}
+			input.NextToken = out.NextToken
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,122 @@
+package athenatest_test
+
+import (
+	"bytes"
+	"database/sql"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	athena "github.com/vx416/go-athenav"
+	"github.com/vx416/go-athenav/athenatest"
+)
+
+func registerUsers(srv *athenatest.Server) {
+	srv.Athena.Register("SELECT id, name, joined FROM users WHERE id > ?", athena.FakeResult{
+		Columns: []athena.FakeColumn{
+			{Name: "id", Type: "integer"},
+			{Name: "name", Type: "varchar"},
+			{Name: "joined", Type: "timestamp"},
+		},
+		Rows: [][]interface{}{
+			{1, `vic "v"`, time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)},
+			{2, nil, nil},
+		},
+		RunningPolls: 1,
+	})
+}
+
+type user struct {
+	id     int
+	name   *string
+	joined *time.Time
+}
+
+func queryUsers(t *testing.T, db *sql.DB) []user {
+	rows, err := db.Query("SELECT id, name, joined FROM users WHERE id > ?", 0)
+	require.NoError(t, err)
+	defer rows.Close()
+	var users []user
+	for rows.Next() {
+		var u user
+		require.NoError(t, rows.Scan(&u.id, &u.name, &u.joined))
+		users = append(users, u)
+	}
+	require.NoError(t, rows.Err())
+	return users
+}
+
+func assertUsers(t *testing.T, users []user) {
+	require.Len(t, users, 2)
+	assert.Equal(t, 1, users[0].id)
+	assert.Equal(t, `vic "v"`, *users[0].name)
+	assert.Equal(t, time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), *users[0].joined)
+	assert.Equal(t, 2, users[1].id)
+	assert.Nil(t, users[1].name)
+	assert.Nil(t, users[1].joined)
+}
+
+func TestServer_DSN(t *testing.T) {
+	srv := athenatest.NewServer()
+	defer srv.Close()
+	registerUsers(srv)
+
+	db, err := sql.Open("athena", srv.DSN())
+	require.NoError(t, err)
+	defer db.Close()
+	assertUsers(t, queryUsers(t, db))
+
+	queries := srv.Athena.Queries()
+	require.Len(t, queries, 1)
+	assert.Equal(t, athenatest.Database, queries[0].Database)
+	assert.Equal(t, []string{"0"}, queries[0].Params)
+
+	srv.Athena.Register("SELECT broken", athena.FakeResult{FailureReason: "SYNTAX_ERROR: line 1:8"})
+	_, err = db.Query("SELECT broken")
+	assert.EqualError(t, err, "SYNTAX_ERROR: line 1:8")
+	_, err = db.Query("SELECT unknown")
+	assert.ErrorContains(t, err, "InvalidRequestException: no fake result registered for query: SELECT unknown")
+}
+
+func TestServer_ResultModeS3(t *testing.T) {
+	srv := athenatest.NewServer()
+	defer srv.Close()
+	registerUsers(srv)
+
+	cfg := srv.Config()
+	cfg.ResultMode = athena.ResultModeS3
+	db, err := athena.Open(cfg)
+	require.NoError(t, err)
+	defer db.Close()
+	assertUsers(t, queryUsers(t, db))
+}
+
+func TestServer_S3Objects(t *testing.T) {
+	srv := athenatest.NewServer()
+	defer srv.Close()
+
+	client := s3.New(session.Must(session.NewSession(srv.AWSConfig())))
+	_, err := client.PutObject(&s3.PutObjectInput{
+		Bucket: aws.String("data"),
+		Key:    aws.String("users/fixture.json"),
+		Body:   bytes.NewReader([]byte(`{"id":1}`)),
+	})
+	require.NoError(t, err)
+	body, ok := srv.Object("data", "users/fixture.json")
+	assert.True(t, ok)
+	assert.Equal(t, `{"id":1}`, string(body))
+
+	obj, err := client.GetObject(&s3.GetObjectInput{Bucket: aws.String("data"), Key: aws.String("users/fixture.json")})
+	require.NoError(t, err)
+	body, err = io.ReadAll(obj.Body)
+	require.NoError(t, err)
+	assert.Equal(t, `{"id":1}`, string(body))
+
+	_, err = client.GetObject(&s3.GetObjectInput{Bucket: aws.String("data"), Key: aws.String("missing")})
+	assert.ErrorContains(t, err, "NoSuchKey")
+}
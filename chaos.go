@@ -0,0 +1,228 @@
+package athena
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/aws/aws-sdk-go/service/athena/athenaiface"
+)
+
+// ChaosConfig configures the faults a ChaosAthena injects. Rates are
+// probabilities between 0 and 1.
+type ChaosConfig struct {
+	// Seed seeds the random source. Runs with the same seed inject the same
+	// faults into the same sequence of calls. The calls of concurrent
+	// goroutines draw from the same source in the order they happen to
+	// run, so faults are only reproducible when calls are made from a
+	// single goroutine.
+	Seed int64
+
+	// Latency is added to every call, plus a random duration up to Jitter.
+	Latency time.Duration
+	Jitter  time.Duration
+
+	// ThrottleRate is the rate of calls failing with a
+	// TooManyRequestsException.
+	ThrottleRate float64
+	// ServerErrorRate is the rate of calls failing with a 500 response.
+	ServerErrorRate float64
+	// QueryFailureRate is the rate of started queries ending FAILED. They
+	// are stopped on the wrapped client.
+	QueryFailureRate float64
+	// FailureReason is the state change reason of the queries failed on
+	// purpose. Defaults to a generic internal error.
+	FailureReason string
+}
+
+const defaultChaosFailureReason = "GENERIC_INTERNAL_ERROR: injected failure"
+
+// ChaosAthena wraps an Athena client, e.g. a real one, a FakeAthena or an
+// athenamock.AthenaAPI, and injects latency, throttling, server errors and
+// query failures into the calls the driver makes, to test how applications
+// cope with them. Other calls are passed through as is.
+type ChaosAthena struct {
+	athenaiface.AthenaAPI
+
+	cfg ChaosConfig
+
+	mu     sync.Mutex
+	rand   *rand.Rand
+	failed map[string]bool
+}
+
+// NewChaosAthena returns a ChaosAthena injecting the faults of cfg into the
+// calls to client.
+func NewChaosAthena(client athenaiface.AthenaAPI, cfg ChaosConfig) *ChaosAthena {
+	if cfg.FailureReason == "" {
+		cfg.FailureReason = defaultChaosFailureReason
+	}
+	return &ChaosAthena{
+		AthenaAPI: client,
+		cfg:       cfg,
+		rand:      rand.New(rand.NewSource(cfg.Seed)),
+		failed:    make(map[string]bool),
+	}
+}
+
+// inject waits for the latency of a call to operation, then returns the
+// error to fail it with, if any.
+func (c *ChaosAthena) inject(ctx context.Context, operation string) error {
+	c.mu.Lock()
+	delay := c.cfg.Latency
+	if c.cfg.Jitter > 0 {
+		delay += time.Duration(c.rand.Int63n(int64(c.cfg.Jitter)))
+	}
+	throttled := c.roll(c.cfg.ThrottleRate)
+	serverErr := c.roll(c.cfg.ServerErrorRate)
+	c.mu.Unlock()
+
+	if delay > 0 {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return awserr.New(request.CanceledErrorCode, "request context canceled", ctx.Err())
+		case <-timer.C:
+		}
+	}
+
+	switch {
+	case throttled:
+		return awserr.NewRequestFailure(
+			awserr.New(athena.ErrCodeTooManyRequestsException, operation+": rate exceeded (injected)", nil),
+			400, "chaos")
+	case serverErr:
+		return awserr.NewRequestFailure(
+			awserr.New(athena.ErrCodeInternalServerException, operation+": internal server error (injected)", nil),
+			500, "chaos")
+	}
+	return nil
+}
+
+// roll reports whether an event of the given rate happens. c.mu must be
+// held.
+func (c *ChaosAthena) roll(rate float64) bool {
+	return rate > 0 && c.rand.Float64() < rate
+}
+
+func (c *ChaosAthena) StartQueryExecution(input *athena.StartQueryExecutionInput) (*athena.StartQueryExecutionOutput, error) {
+	if err := c.inject(context.Background(), "StartQueryExecution"); err != nil {
+		return nil, err
+	}
+	out, err := c.AthenaAPI.StartQueryExecution(input)
+	return c.started(out, err, func(stop *athena.StopQueryExecutionInput) {
+		c.AthenaAPI.StopQueryExecution(stop)
+	})
+}
+
+func (c *ChaosAthena) StartQueryExecutionWithContext(ctx aws.Context, input *athena.StartQueryExecutionInput, opts ...request.Option) (*athena.StartQueryExecutionOutput, error) {
+	if err := c.inject(ctx, "StartQueryExecution"); err != nil {
+		return nil, err
+	}
+	out, err := c.AthenaAPI.StartQueryExecutionWithContext(ctx, input, opts...)
+	return c.started(out, err, func(stop *athena.StopQueryExecutionInput) {
+		c.AthenaAPI.StopQueryExecutionWithContext(context.WithoutCancel(ctx), stop)
+	})
+}
+
+// started decides whether the started query fails. A query failed on purpose
+// is stopped with stop, so that it doesn't keep running, and billing, on the
+// wrapped client. Stopping is best effort.
+func (c *ChaosAthena) started(out *athena.StartQueryExecutionOutput, err error, stop func(*athena.StopQueryExecutionInput)) (*athena.StartQueryExecutionOutput, error) {
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	failed := c.roll(c.cfg.QueryFailureRate)
+	if failed {
+		c.failed[aws.StringValue(out.QueryExecutionId)] = true
+	}
+	c.mu.Unlock()
+	if failed {
+		stop(&athena.StopQueryExecutionInput{QueryExecutionId: out.QueryExecutionId})
+	}
+	return out, nil
+}
+
+func (c *ChaosAthena) GetQueryExecution(input *athena.GetQueryExecutionInput) (*athena.GetQueryExecutionOutput, error) {
+	if err := c.inject(context.Background(), "GetQueryExecution"); err != nil {
+		return nil, err
+	}
+	out, err := c.AthenaAPI.GetQueryExecution(input)
+	return c.execution(input, out, err)
+}
+
+func (c *ChaosAthena) GetQueryExecutionWithContext(ctx aws.Context, input *athena.GetQueryExecutionInput, opts ...request.Option) (*athena.GetQueryExecutionOutput, error) {
+	if err := c.inject(ctx, "GetQueryExecution"); err != nil {
+		return nil, err
+	}
+	out, err := c.AthenaAPI.GetQueryExecutionWithContext(ctx, input, opts...)
+	return c.execution(input, out, err)
+}
+
+// execution reports the queries failed on purpose as FAILED. A query is
+// forgotten once reported, as the driver stops polling it then.
+func (c *ChaosAthena) execution(input *athena.GetQueryExecutionInput, out *athena.GetQueryExecutionOutput, err error) (*athena.GetQueryExecutionOutput, error) {
+	if err != nil {
+		return nil, err
+	}
+
+	if out.QueryExecution == nil {
+		return out, nil
+	}
+	c.mu.Lock()
+	failed := c.failed[aws.StringValue(input.QueryExecutionId)]
+	delete(c.failed, aws.StringValue(input.QueryExecutionId))
+	c.mu.Unlock()
+	if !failed {
+		return out, nil
+	}
+	exec := *out.QueryExecution
+	exec.Status = &athena.QueryExecutionStatus{
+		State:             aws.String(athena.QueryExecutionStateFailed),
+		StateChangeReason: aws.String(c.cfg.FailureReason),
+		AthenaError: &athena.AthenaError{
+			ErrorCategory: aws.Int64(1),
+			ErrorMessage:  aws.String(c.cfg.FailureReason),
+			Retryable:     aws.Bool(true),
+		},
+	}
+	return &athena.GetQueryExecutionOutput{QueryExecution: &exec}, nil
+}
+
+func (c *ChaosAthena) GetQueryResults(input *athena.GetQueryResultsInput) (*athena.GetQueryResultsOutput, error) {
+	if err := c.inject(context.Background(), "GetQueryResults"); err != nil {
+		return nil, err
+	}
+	return c.AthenaAPI.GetQueryResults(input)
+}
+
+func (c *ChaosAthena) GetQueryResultsWithContext(ctx aws.Context, input *athena.GetQueryResultsInput, opts ...request.Option) (*athena.GetQueryResultsOutput, error) {
+	if err := c.inject(ctx, "GetQueryResults"); err != nil {
+		return nil, err
+	}
+	return c.AthenaAPI.GetQueryResultsWithContext(ctx, input, opts...)
+}
+
+func (c *ChaosAthena) StopQueryExecution(input *athena.StopQueryExecutionInput) (*athena.StopQueryExecutionOutput, error) {
+	if err := c.inject(context.Background(), "StopQueryExecution"); err != nil {
+		return nil, err
+	}
+	return c.AthenaAPI.StopQueryExecution(input)
+}
+
+func (c *ChaosAthena) StopQueryExecutionWithContext(ctx aws.Context, input *athena.StopQueryExecutionInput, opts ...request.Option) (*athena.StopQueryExecutionOutput, error) {
+	if err := c.inject(ctx, "StopQueryExecution"); err != nil {
+		return nil, err
+	}
+	return c.AthenaAPI.StopQueryExecutionWithContext(ctx, input, opts...)
+}
+
+var _ athenaiface.AthenaAPI = (*ChaosAthena)(nil)
@@ -0,0 +1,89 @@
+package athena
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChaosAthena_Seeded(t *testing.T) {
+	faults := func(seed int64) []string {
+		fake := NewFakeAthena()
+		fake.Register("SELECT 1", FakeResult{})
+		chaos := NewChaosAthena(fake, ChaosConfig{Seed: seed, ThrottleRate: 0.3, ServerErrorRate: 0.2})
+
+		var codes []string
+		for i := 0; i < 50; i++ {
+			_, err := chaos.StartQueryExecution(&athena.StartQueryExecutionInput{QueryString: aws.String("SELECT 1")})
+			code := "ok"
+			var aerr awserr.Error
+			if errors.As(err, &aerr) {
+				code = aerr.Code()
+				assert.True(t, isTransientError(err), err)
+			}
+			codes = append(codes, code)
+		}
+		return codes
+	}
+
+	codes := faults(42)
+	assert.Equal(t, codes, faults(42))
+	assert.NotEqual(t, codes, faults(7))
+	assert.Contains(t, codes, athena.ErrCodeTooManyRequestsException)
+	assert.Contains(t, codes, athena.ErrCodeInternalServerException)
+	assert.Contains(t, codes, "ok")
+}
+
+func TestChaosAthena_QueryFailures(t *testing.T) {
+	fake := NewFakeAthena()
+	fake.Register("SELECT 1", FakeResult{Columns: []FakeColumn{{Name: "one", Type: "integer"}}, Rows: [][]interface{}{{1}}})
+	chaos := NewChaosAthena(fake, ChaosConfig{QueryFailureRate: 1})
+	db := openMockDB(t, chaos)
+
+	for i := 0; i < 3; i++ {
+		_, err := db.Query("SELECT 1")
+		var failed *QueryFailedError
+		require.ErrorAs(t, err, &failed)
+		assert.Equal(t, defaultChaosFailureReason, failed.Reason)
+		assert.Equal(t, int64(1), failed.ErrorCategory)
+	}
+	// the failed queries are forgotten once reported
+	assert.Empty(t, chaos.failed)
+	// and stopped on the wrapped client
+	queries := fake.Queries()
+	require.Len(t, queries, 3)
+	for _, q := range queries {
+		assert.True(t, q.Stopped, q.ID)
+	}
+}
+
+func TestChaosAthena_Latency(t *testing.T) {
+	fake := NewFakeAthena()
+	fake.Register("SELECT 1", FakeResult{Columns: []FakeColumn{{Name: "one", Type: "integer"}}, Rows: [][]interface{}{{1}}})
+	chaos := NewChaosAthena(fake, ChaosConfig{Latency: 20 * time.Millisecond, Jitter: 10 * time.Millisecond})
+	db := openMockDB(t, chaos)
+
+	start := time.Now()
+	var one int
+	require.NoError(t, db.QueryRow("SELECT 1").Scan(&one))
+	assert.Equal(t, 1, one)
+	// start, poll and fetch the results
+	assert.GreaterOrEqual(t, time.Since(start), 60*time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+	defer cancel()
+	_, err := chaos.GetQueryResultsWithContext(ctx, &athena.GetQueryResultsInput{QueryExecutionId: aws.String("fake-query-1")})
+	// like the SDK, a request canceled by its context fails with RequestCanceled
+	var aerr awserr.Error
+	require.ErrorAs(t, err, &aerr)
+	assert.Equal(t, request.CanceledErrorCode, aerr.Code())
+	assert.False(t, isTransientError(err))
+}
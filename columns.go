@@ -0,0 +1,67 @@
+package athena
+
+import (
+	"reflect"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/athena"
+)
+
+// resultColumns implements the column metadata methods of driver.Rows from
+// the ColumnInfo of a query result.
+type resultColumns struct {
+	columns []*athena.ColumnInfo
+}
+
+func (r *resultColumns) Columns() []string {
+	var columns []string
+	for _, colInfo := range r.columns {
+		columns = append(columns, *colInfo.Name)
+	}
+
+	return columns
+}
+
+func (r *resultColumns) ColumnTypeDatabaseTypeName(index int) string {
+	colInfo := r.columns[index]
+	if colInfo.Type != nil {
+		return *colInfo.Type
+	}
+	return ""
+}
+
+func (r *resultColumns) ColumnTypeScanType(index int) reflect.Type {
+	colInfo := r.columns[index]
+	nullable, ok := r.ColumnTypeNullable(index)
+	return scanType(aws.StringValue(colInfo.Type), nullable || !ok)
+}
+
+func (r *resultColumns) ColumnTypeNullable(index int) (nullable, ok bool) {
+	colInfo := r.columns[index]
+	switch aws.StringValue(colInfo.Nullable) {
+	case athena.ColumnNullableNotNull:
+		return false, true
+	case athena.ColumnNullableNullable:
+		return true, true
+	default:
+		return false, false
+	}
+}
+
+func (r *resultColumns) ColumnTypeLength(index int) (length int64, ok bool) {
+	colInfo := r.columns[index]
+	switch normalizeType(aws.StringValue(colInfo.Type)) {
+	case "varchar", "char", "string":
+		return aws.Int64Value(colInfo.Precision), colInfo.Precision != nil
+	default:
+		return 0, false
+	}
+}
+
+func (r *resultColumns) ColumnTypePrecisionScale(index int) (precision, scale int64, ok bool) {
+	colInfo := r.columns[index]
+	if colInfo.Type == nil || !isDecimalType(*colInfo.Type) {
+		return 0, 0, false
+	}
+	return aws.Int64Value(colInfo.Precision), aws.Int64Value(colInfo.Scale), true
+}
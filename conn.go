@@ -5,11 +5,13 @@ import (
 	"database/sql/driver"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/athena"
 	"github.com/aws/aws-sdk-go/service/athena/athenaiface"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 )
 
 type conn struct {
@@ -22,6 +24,15 @@ type conn struct {
 	pollFrequency      time.Duration
 	workGroup          *string
 	dataCataLog        *string
+	loc                *time.Location
+	prefetchDepth      int
+	pageSize           int64
+	retry              retryPolicy
+	resultMode         ResultMode
+	s3                 s3iface.S3API
+	// tracker records the queries being waited on, so closing the connector
+	// can stop them.
+	tracker *queryTracker
 }
 
 func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
@@ -38,28 +49,80 @@ func (c *conn) ExecContext(ctx context.Context, query string, args []driver.Name
 	// 	panic("Athena doesn't support prepared statements. Format your own arguments.")
 	// }
 
-	_, err := c.runQuery(ctx, query, args)
-	return nil, err
+	rows, err := c.runQuery(ctx, query, args)
+	if err != nil {
+		return nil, err
+	}
+	return nil, rows.Close()
 }
 
 func (c *conn) runQuery(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if opts, ok := unloadOptionsFromContext(ctx); ok {
+		return c.runUnload(ctx, query, args, opts)
+	}
+
+	pageSize := c.pageSize
+	if size, ok := pageSizeFromContext(ctx); ok {
+		if err := validatePageSize(size); err != nil {
+			return nil, err
+		}
+		pageSize = size
+	}
+	if c.resultMode == ResultModeS3 && c.s3 == nil {
+		return nil, errors.New("result mode s3 requires an S3 client")
+	}
+
 	queryID, err := c.startQuery(query, args)
 	if err != nil {
 		return nil, err
 	}
 
-	if err := c.waitOnQuery(ctx, queryID); err != nil {
+	exec, err := c.waitOnQuery(ctx, queryID)
+	if err != nil {
 		return nil, err
 	}
 
-	return newRows(rowsConfig{
-		Athena:  c.athena,
-		QueryID: queryID,
-		// todo add check for ddl queries to not skip header(#10)
-		SkipHeader: true,
+	if c.resultMode == ResultModeS3 {
+		if location, ok := c.resultFileLocation(exec); ok {
+			return newS3Rows(ctx, s3RowsConfig{
+				Athena:         c.athena,
+				S3:             c.s3,
+				QueryID:        queryID,
+				OutputLocation: location,
+				Location:       c.loc,
+			})
+		}
+	}
+
+	utilityColumns, _ := utilityColumnsOf(exec)
+	return newRows(ctx, rowsConfig{
+		Athena:         c.athena,
+		QueryID:        queryID,
+		Location:       c.loc,
+		SkipHeader:     hasHeaderRow(exec),
+		UtilityColumns: utilityColumns,
+		PrefetchDepth:  c.prefetchDepth,
+		PageSize:       pageSize,
+		Retry:          c.retry,
 	})
 }
 
+// resultFileLocation returns the S3 URL of the CSV file holding the result of
+// exec. Only queries with a header row, e.g. SELECT, write their result as CSV.
+func (c *conn) resultFileLocation(exec *athena.QueryExecution) (string, bool) {
+	if !hasHeaderRow(exec) {
+		return "", false
+	}
+	if exec.ResultConfiguration != nil && exec.ResultConfiguration.OutputLocation != nil {
+		location := *exec.ResultConfiguration.OutputLocation
+		return location, strings.HasSuffix(location, ".csv")
+	}
+	if exec.QueryExecutionId == nil || c.OutputLocation == "" {
+		return "", false
+	}
+	return strings.TrimSuffix(c.OutputLocation, "/") + "/" + *exec.QueryExecutionId + ".csv", true
+}
+
 // startQuery starts an Athena query and returns its ID.
 func (c *conn) startQuery(query string, args []driver.NamedValue) (string, error) {
 	input := &athena.StartQueryExecutionInput{
@@ -89,39 +152,41 @@ func (c *conn) startQuery(query string, args []driver.NamedValue) (string, error
 		return "", err
 	}
 
+	c.tracker.add(*resp.QueryExecutionId)
 	return *resp.QueryExecutionId, nil
 }
 
-// waitOnQuery blocks until a query finishes, returning an error if it failed.
-func (c *conn) waitOnQuery(ctx context.Context, queryID string) error {
+// waitOnQuery blocks until a query finishes, returning its execution details
+// or an error if it failed. The query is stopped if ctx ends first.
+func (c *conn) waitOnQuery(ctx context.Context, queryID string) (*athena.QueryExecution, error) {
+	defer c.tracker.remove(queryID)
+
 	pollFreq := c.pollFrequency
 	for {
 		statusResp, err := c.athena.GetQueryExecutionWithContext(ctx, &athena.GetQueryExecutionInput{
 			QueryExecutionId: aws.String(queryID),
 		})
 		if err != nil {
-			return err
+			if ctx.Err() != nil {
+				return nil, c.stopQuery(ctx, queryID)
+			}
+			return nil, err
 		}
 
 		switch *statusResp.QueryExecution.Status.State {
 		case athena.QueryExecutionStateCancelled:
-			return context.Canceled
+			return nil, context.Canceled
 		case athena.QueryExecutionStateFailed:
-			reason := *statusResp.QueryExecution.Status.StateChangeReason
-			return errors.New(reason)
+			return nil, newQueryFailedError(queryID, statusResp.QueryExecution)
 		case athena.QueryExecutionStateSucceeded:
-			return nil
+			return statusResp.QueryExecution, nil
 		case athena.QueryExecutionStateQueued:
 		case athena.QueryExecutionStateRunning:
 		}
 
 		select {
 		case <-ctx.Done():
-			c.athena.StopQueryExecution(&athena.StopQueryExecutionInput{
-				QueryExecutionId: aws.String(queryID),
-			})
-
-			return ctx.Err()
+			return nil, c.stopQuery(ctx, queryID)
 		case <-time.After(pollFreq):
 			pollFreq += c.pollRetryIncrement
 			if pollFreq > c.maxRetryDuration {
@@ -132,6 +197,46 @@ func (c *conn) waitOnQuery(ctx context.Context, queryID string) error {
 	}
 }
 
+// QueryFailedError is returned for queries that end in the FAILED state.
+type QueryFailedError struct {
+	QueryID string
+	// Reason is the state change reason of the query.
+	Reason string
+	// ErrorCategory is 1 for system errors, 2 for user errors and 3 for
+	// other errors, or zero if Athena didn't report it.
+	ErrorCategory int64
+	// ErrorType is the Athena error type, or zero if Athena didn't report it.
+	ErrorType int64
+}
+
+func newQueryFailedError(queryID string, exec *athena.QueryExecution) *QueryFailedError {
+	err := &QueryFailedError{
+		QueryID: queryID,
+		Reason:  aws.StringValue(exec.Status.StateChangeReason),
+	}
+	if athenaErr := exec.Status.AthenaError; athenaErr != nil {
+		err.ErrorCategory = aws.Int64Value(athenaErr.ErrorCategory)
+		err.ErrorType = aws.Int64Value(athenaErr.ErrorType)
+		if err.Reason == "" {
+			err.Reason = aws.StringValue(athenaErr.ErrorMessage)
+		}
+	}
+	return err
+}
+
+func (e *QueryFailedError) Error() string {
+	return e.Reason
+}
+
+// stopQuery stops queryID after ctx ended, returning the context error along
+// with any error stopping the query.
+func (c *conn) stopQuery(ctx context.Context, queryID string) error {
+	if err := stopQuery(c.athena, queryID); err != nil {
+		return errors.Join(ctx.Err(), err)
+	}
+	return ctx.Err()
+}
+
 func (c *conn) Prepare(query string) (driver.Stmt, error) {
 	panic("Athena doesn't support prepared statements")
 }
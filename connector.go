@@ -0,0 +1,180 @@
+package athena
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/aws/aws-sdk-go/service/athena/athenaiface"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// stopQueryTimeout bounds StopQueryExecution calls made after the query
+// context is done.
+var stopQueryTimeout = 10 * time.Second
+
+// connector is a driver.Connector. Closing it, which sql.DB.Close does,
+// stops every query its connections still wait on.
+type connector struct {
+	driver  *Driver
+	cfg     Config
+	athena  athenaiface.AthenaAPI
+	s3      s3iface.S3API
+	tracker *queryTracker
+}
+
+// NewConnector returns a driver.Connector for use with sql.OpenDB.
+func NewConnector(cfg Config) (driver.Connector, error) {
+	return newConnector(&Driver{cfg: &cfg}, cfg)
+}
+
+func newConnector(d *Driver, cfg Config) (*connector, error) {
+	if cfg.PollFrequency == 0 {
+		cfg.PollFrequency = defaultPollFrequency
+	}
+	if cfg.PollRetryIncrement == 0 {
+		cfg.PollRetryIncrement = defaultRetryDurationIncrement
+	}
+	if cfg.MaxRetryDuration == 0 {
+		cfg.MaxRetryDuration = defaultMaxRetryDuration
+	}
+	if cfg.Location == nil {
+		cfg.Location = time.UTC
+	}
+	if cfg.PageSize == 0 {
+		cfg.PageSize = maxResultCnt
+	}
+	if err := validatePageSize(cfg.PageSize); err != nil {
+		return nil, err
+	}
+	if cfg.PageRetries == 0 {
+		cfg.PageRetries = defaultPageRetries
+	}
+	if cfg.ResultMode == "" {
+		cfg.ResultMode = ResultModeAPI
+	}
+	if cfg.Athena == nil {
+		if cfg.Session == nil {
+			return nil, errors.New("session is required")
+		}
+		cfg.Athena = athena.New(cfg.Session)
+	}
+	if cfg.S3 == nil && cfg.Session != nil {
+		cfg.S3 = s3.New(cfg.Session)
+	}
+
+	return &connector{
+		driver:  d,
+		cfg:     cfg,
+		athena:  cfg.Athena,
+		s3:      cfg.S3,
+		tracker: newQueryTracker(),
+	}, nil
+}
+
+func (c *connector) Connect(context.Context) (driver.Conn, error) {
+	cfg := c.cfg
+	return &conn{
+		athena:             c.athena,
+		db:                 cfg.Database,
+		OutputLocation:     cfg.OutputLocation,
+		pollFrequency:      cfg.PollFrequency,
+		pollRetryIncrement: cfg.PollRetryIncrement,
+		maxRetryDuration:   cfg.MaxRetryDuration,
+		workGroup:          cfg.WorkGroup,
+		dataCataLog:        cfg.DataCateLog,
+		loc:                cfg.Location,
+		prefetchDepth:      cfg.PrefetchDepth,
+		pageSize:           cfg.PageSize,
+		retry: retryPolicy{
+			maxRetries: cfg.PageRetries,
+			backoff:    defaultRetryBackoff,
+			maxBackoff: defaultMaxRetryBackoff,
+			hook:       cfg.OnRetry,
+		},
+		resultMode: cfg.ResultMode,
+		s3:         c.s3,
+		tracker:    c.tracker,
+	}, nil
+}
+
+func (c *connector) Driver() driver.Driver {
+	return c.driver
+}
+
+// Close stops every query still running on the connector's connections.
+func (c *connector) Close() error {
+	return c.tracker.stopAll(c.athena)
+}
+
+var _ driver.Connector = (*connector)(nil)
+var _ io.Closer = (*connector)(nil)
+var _ driver.DriverContext = (*Driver)(nil)
+
+// queryTracker tracks the queries that were started but not waited on yet.
+type queryTracker struct {
+	mu      sync.Mutex
+	running map[string]struct{}
+}
+
+func newQueryTracker() *queryTracker {
+	return &queryTracker{running: make(map[string]struct{})}
+}
+
+func (t *queryTracker) add(queryID string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.running[queryID] = struct{}{}
+	t.mu.Unlock()
+}
+
+func (t *queryTracker) remove(queryID string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	delete(t.running, queryID)
+	t.mu.Unlock()
+}
+
+func (t *queryTracker) stopAll(client athenaiface.AthenaAPI) error {
+	t.mu.Lock()
+	queryIDs := make([]string, 0, len(t.running))
+	for queryID := range t.running {
+		queryIDs = append(queryIDs, queryID)
+	}
+	t.running = make(map[string]struct{})
+	t.mu.Unlock()
+
+	var errs []error
+	for _, queryID := range queryIDs {
+		if err := stopQuery(client, queryID); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// stopQuery stops queryID. It doesn't take a context as it's called once the
+// query context is done.
+func stopQuery(client athenaiface.AthenaAPI, queryID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), stopQueryTimeout)
+	defer cancel()
+
+	_, err := client.StopQueryExecutionWithContext(ctx, &athena.StopQueryExecutionInput{
+		QueryExecutionId: aws.String(queryID),
+	})
+	if err != nil {
+		return fmt.Errorf("stop query %s: %w", queryID, err)
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package athena
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/aws/aws-sdk-go/service/athena/athenaiface"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// runningAthenaClient starts queries that keep running until stopped.
+type runningAthenaClient struct {
+	athenaiface.AthenaAPI
+	stopErr error
+
+	mu      sync.Mutex
+	started int
+	stopped []string
+}
+
+func (m *runningAthenaClient) StartQueryExecution(*athena.StartQueryExecutionInput) (*athena.StartQueryExecutionOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.started++
+	return &athena.StartQueryExecutionOutput{QueryExecutionId: aws.String(fmt.Sprintf("query-%d", m.started))}, nil
+}
+
+func (m *runningAthenaClient) GetQueryExecutionWithContext(aws.Context, *athena.GetQueryExecutionInput, ...request.Option) (*athena.GetQueryExecutionOutput, error) {
+	return &athena.GetQueryExecutionOutput{QueryExecution: &athena.QueryExecution{
+		Status: &athena.QueryExecutionStatus{State: aws.String(athena.QueryExecutionStateRunning)},
+	}}, nil
+}
+
+func (m *runningAthenaClient) StopQueryExecutionWithContext(_ aws.Context, input *athena.StopQueryExecutionInput, _ ...request.Option) (*athena.StopQueryExecutionOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.stopped = append(m.stopped, *input.QueryExecutionId)
+	return &athena.StopQueryExecutionOutput{}, m.stopErr
+}
+
+func TestConn_WaitOnQueryStopsQueryOnContextEnd(t *testing.T) {
+	client := &runningAthenaClient{}
+	tracker := newQueryTracker()
+	c := &conn{athena: client, tracker: tracker, pollFrequency: time.Millisecond, maxRetryDuration: time.Millisecond}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := c.QueryContext(ctx, "SELECT 1", nil)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, []string{"query-1"}, client.stopped)
+	assert.Empty(t, tracker.running)
+
+	client.stopErr = errors.New("access denied")
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	_, err = c.QueryContext(ctx, "SELECT 1", nil)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.ErrorContains(t, err, "stop query query-2: access denied")
+}
+
+func TestConnector_CloseStopsRunningQueries(t *testing.T) {
+	client := &runningAthenaClient{}
+	c := &connector{athena: client, tracker: newQueryTracker()}
+	driverConn, err := c.Connect(context.Background())
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errs := make(chan error, 1)
+	go func() {
+		_, err := driverConn.(*conn).QueryContext(ctx, "SELECT 1", nil)
+		errs <- err
+	}()
+	require.Eventually(t, func() bool {
+		c.tracker.mu.Lock()
+		defer c.tracker.mu.Unlock()
+		return len(c.tracker.running) == 1
+	}, time.Second, time.Millisecond)
+
+	require.NoError(t, c.Close())
+	client.mu.Lock()
+	assert.Equal(t, []string{"query-1"}, client.stopped)
+	client.mu.Unlock()
+
+	cancel()
+	assert.ErrorIs(t, <-errs, context.Canceled)
+}
+
+// openMockDB opens a db whose queries run on client.
+func openMockDB(t *testing.T, client athenaiface.AthenaAPI) *sql.DB {
+	connector, err := NewConnector(Config{
+		Athena:             client,
+		PollFrequency:      time.Millisecond,
+		PollRetryIncrement: time.Millisecond,
+		MaxRetryDuration:   time.Millisecond,
+	})
+	require.NoError(t, err)
+	db := sql.OpenDB(connector)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
@@ -0,0 +1,27 @@
+package athena
+
+import (
+	"context"
+	"fmt"
+)
+
+type pageSizeKey struct{}
+
+// WithPageSize returns a context that makes queries run with it fetch size
+// rows per GetQueryResults call, overriding Config.PageSize. size must be
+// between 1 and 1000.
+func WithPageSize(ctx context.Context, size int64) context.Context {
+	return context.WithValue(ctx, pageSizeKey{}, size)
+}
+
+func pageSizeFromContext(ctx context.Context) (int64, bool) {
+	size, ok := ctx.Value(pageSizeKey{}).(int64)
+	return size, ok
+}
+
+func validatePageSize(size int64) error {
+	if size < 1 || size > maxResultCnt {
+		return fmt.Errorf("page size must be between 1 and %d, got %d", maxResultCnt, size)
+	}
+	return nil
+}
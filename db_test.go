@@ -7,14 +7,14 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
-	"strings"
+	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/athena"
 	"github.com/aws/aws-sdk-go/service/s3"
-	uuid "github.com/satori/go.uuid"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -34,9 +34,47 @@ func init() {
 	}
 }
 
+// recordingAWS reports whether the tests run against AWS and record their
+// queries to the golden files in testdata, rather than replaying them. Set
+// ATHENA_RECORD=1 to re-record the golden files. The checked in golden files
+// are synthetic: they were recorded against a FakeAthena, not real Athena, so
+// they only check the driver against FakeAthena's idea of Athena until they
+// are re-recorded. Replaying matches the queries of the default S3_BUCKET only.
+func recordingAWS() bool {
+	return os.Getenv("ATHENA_RECORD") != ""
+}
+
+// openTestDB opens a db replaying the golden file testdata/name.json, or
+// recording to it against AWS.
+func openTestDB(t *testing.T, name string) *sql.DB {
+	path := filepath.Join("testdata", name+".json")
+	cfg := Config{
+		Database:       AthenaDatabase,
+		OutputLocation: fmt.Sprintf("s3://%s/output", S3Bucket),
+	}
+	if recordingAWS() {
+		cfg.Session = session.Must(session.NewSession())
+		recorder := NewRecorder(athena.New(cfg.Session), path)
+		t.Cleanup(func() { require.NoError(t, recorder.Save()) })
+		cfg.Athena = recorder
+	} else {
+		replayer, err := NewReplayer(path)
+		require.NoError(t, err)
+		cfg.Athena = replayer
+		cfg.PollFrequency = time.Millisecond
+		cfg.PollRetryIncrement = time.Millisecond
+		cfg.MaxRetryDuration = time.Millisecond
+	}
+
+	db, err := Open(cfg)
+	require.NoError(t, err, "Open")
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
 func TestQuery(t *testing.T) {
 	harness := setup(t)
-	// defer harness.teardown()
+	defer harness.teardown()
 
 	expected := []dummyRow{
 		{
@@ -115,15 +153,11 @@ func TestQuery(t *testing.T) {
 }
 
 func TestOpen(t *testing.T) {
-	db, err := Open(Config{
-		Session:        session.Must(session.NewSession()),
-		Database:       AthenaDatabase,
-		OutputLocation: fmt.Sprintf("s3://%s/noop", S3Bucket),
-	})
-	require.NoError(t, err, "Open")
+	db := openTestDB(t, "open")
 
-	_, err = db.Query("SELECT 1")
-	require.NoError(t, err, "Query")
+	var one int
+	require.NoError(t, db.QueryRow("SELECT 1").Scan(&one), "Query")
+	require.Equal(t, 1, one)
 }
 
 type dummyRow struct {
@@ -149,11 +183,10 @@ type athenaHarness struct {
 }
 
 func setup(t *testing.T) *athenaHarness {
-	harness := athenaHarness{t: t, s3: s3.New(session.New())}
-
-	var err error
-	harness.db, err = sql.Open("athena", fmt.Sprintf("db=%s&output_location=s3://%s/output", AthenaDatabase, S3Bucket))
-	require.NoError(t, err)
+	harness := athenaHarness{t: t, db: openTestDB(t, "query")}
+	if recordingAWS() {
+		harness.s3 = s3.New(session.Must(session.NewSession()))
+	}
 
 	harness.setupTable()
 
@@ -161,9 +194,9 @@ func setup(t *testing.T) *athenaHarness {
 }
 
 func (a *athenaHarness) setupTable() {
-	// tables cannot start with numbers or contain dashes
-	id := uuid.NewV4()
-	a.table = "t_" + strings.Replace(id.String(), "-", "_", -1)
+	// the name is fixed for the queries to match the golden file
+	a.table = "go_athena_query_test"
+	a.mustExec("DROP TABLE IF EXISTS %s", a.table)
 	a.mustExec(`CREATE EXTERNAL TABLE %[1]s (
 	nullValue string,
 	smallintType smallint,
@@ -181,7 +214,7 @@ ROW FORMAT SERDE 'org.openx.data.jsonserde.JsonSerDe'
 WITH SERDEPROPERTIES (
 	'serialization.format' = '1'
 ) LOCATION 's3://%[2]s/%[1]s/';`, a.table, S3Bucket)
-	fmt.Printf("created table: %s", a.table)
+	a.t.Logf("created table: %s", a.table)
 }
 
 func (a *athenaHarness) teardown() {
@@ -201,7 +234,12 @@ func (a *athenaHarness) mustQuery(sql string, args ...interface{}) *sql.Rows {
 	return rows
 }
 
+// uploadData uploads the rows of the table. It's skipped when replaying, as
+// the golden file holds the query results.
 func (a *athenaHarness) uploadData(rows []dummyRow) {
+	if a.s3 == nil {
+		return
+	}
 	var buf bytes.Buffer
 	enc := json.NewEncoder(&buf)
 	for _, row := range rows {
@@ -0,0 +1,156 @@
+package athena
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Decimal is an exact Athena `decimal` value, stored as an unscaled integer
+// and a base-10 scale (value = unscaled * 10^-scale).
+//
+// The driver returns decimal columns as strings, so they can be scanned into
+// a string, a float64 or a Decimal, depending on how much precision you need.
+type Decimal struct {
+	unscaled *big.Int
+	scale    int32
+}
+
+// NewDecimal returns a Decimal equal to unscaled * 10^-scale.
+func NewDecimal(unscaled *big.Int, scale int32) Decimal {
+	return Decimal{unscaled: new(big.Int).Set(unscaled), scale: scale}
+}
+
+// ParseDecimal parses a plain decimal literal such as "-123.4500".
+func ParseDecimal(s string) (Decimal, error) {
+	val := strings.TrimSpace(s)
+	digits := strings.TrimLeft(val, "+-")
+	if len(val)-len(digits) > 1 {
+		return Decimal{}, fmt.Errorf("cannot parse '%s' as decimal", s)
+	}
+
+	intPart, fracPart, _ := strings.Cut(digits, ".")
+	if intPart == "" && fracPart == "" {
+		return Decimal{}, fmt.Errorf("cannot parse '%s' as decimal", s)
+	}
+	for _, c := range intPart + fracPart {
+		if c < '0' || c > '9' {
+			return Decimal{}, fmt.Errorf("cannot parse '%s' as decimal", s)
+		}
+	}
+
+	unscaled, ok := new(big.Int).SetString(intPart+fracPart, 10)
+	if !ok {
+		return Decimal{}, fmt.Errorf("cannot parse '%s' as decimal", s)
+	}
+	if strings.HasPrefix(val, "-") {
+		unscaled.Neg(unscaled)
+	}
+
+	return Decimal{unscaled: unscaled, scale: int32(len(fracPart))}, nil
+}
+
+// Unscaled returns the unscaled integer value of d.
+func (d Decimal) Unscaled() *big.Int {
+	if d.unscaled == nil {
+		return new(big.Int)
+	}
+	return new(big.Int).Set(d.unscaled)
+}
+
+// Scale returns the number of digits after the decimal point.
+func (d Decimal) Scale() int32 {
+	return d.scale
+}
+
+// Rat returns d as an exact rational number.
+func (d Decimal) Rat() *big.Rat {
+	denom := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(d.scale)), nil)
+	return new(big.Rat).SetFrac(d.Unscaled(), denom)
+}
+
+// String formats d with exactly Scale() fractional digits.
+func (d Decimal) String() string {
+	unscaled := d.Unscaled()
+	digits := new(big.Int).Abs(unscaled).String()
+	if d.scale > 0 {
+		if pad := int(d.scale) + 1 - len(digits); pad > 0 {
+			digits = strings.Repeat("0", pad) + digits
+		}
+		point := len(digits) - int(d.scale)
+		digits = digits[:point] + "." + digits[point:]
+	}
+	if unscaled.Sign() < 0 {
+		return "-" + digits
+	}
+	return digits
+}
+
+func (d Decimal) MarshalJSON() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+func (d Decimal) ToQueryValue() string {
+	return fmt.Sprintf("DECIMAL '%s'", d.String())
+}
+
+// Value implements driver.Valuer.
+func (d Decimal) Value() (driver.Value, error) {
+	return d.String(), nil
+}
+
+// Scan implements sql.Scanner.
+func (d *Decimal) Scan(src interface{}) error {
+	var (
+		parsed Decimal
+		err    error
+	)
+	switch v := src.(type) {
+	case string:
+		parsed, err = ParseDecimal(v)
+	case []byte:
+		parsed, err = ParseDecimal(string(v))
+	case int64:
+		parsed = Decimal{unscaled: big.NewInt(v)}
+	case float64:
+		parsed, err = ParseDecimal(strconv.FormatFloat(v, 'f', -1, 64))
+	case nil:
+		return errors.New("cannot scan NULL into Decimal, use NullDecimal instead")
+	default:
+		return fmt.Errorf("cannot scan %T into Decimal", src)
+	}
+	if err != nil {
+		return err
+	}
+
+	*d = parsed
+	return nil
+}
+
+// NullDecimal is a Decimal that may be NULL.
+type NullDecimal struct {
+	Decimal Decimal
+	Valid   bool
+}
+
+// Value implements driver.Valuer.
+func (n NullDecimal) Value() (driver.Value, error) {
+	if !n.Valid {
+		return nil, nil
+	}
+	return n.Decimal.Value()
+}
+
+// Scan implements sql.Scanner.
+func (n *NullDecimal) Scan(src interface{}) error {
+	if src == nil {
+		n.Decimal, n.Valid = Decimal{}, false
+		return nil
+	}
+
+	n.Valid = true
+	return n.Decimal.Scan(src)
+}
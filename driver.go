@@ -1,37 +1,60 @@
 package athena
 
 import (
+	"context"
 	"database/sql"
 	"database/sql/driver"
 	"errors"
 	"fmt"
 	"net/url"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/athena"
 	"github.com/aws/aws-sdk-go/service/athena/athenaiface"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 )
 
 var (
-	openFromSessionMutex          sync.Mutex
-	openFromSessionCount          int
 	defaultPollFrequency          = 1 * time.Second
 	defaultMaxRetryDuration       = 3 * time.Second
 	defaultRetryDurationIncrement = 300 * time.Millisecond
-	mockEnabled                   = false
-	mockAthenaClientImpl          athenaiface.AthenaAPI
 )
 
-// EnableMockMode allows you to use a mock implementation of the Athena API.
+var (
+	mockMu               sync.RWMutex
+	mockAthenaClientImpl athenaiface.AthenaAPI
+)
+
+// EnableMockMode makes every connection opened by the "athena" driver use
+// mockAthenaClient, until DisableMockMode is called.
+//
+// Deprecated: EnableMockMode affects every sql.Open in the binary. Set
+// Config.Athena and open the database with NewConnector or Open instead.
 func EnableMockMode(mockAthenaClient athenaiface.AthenaAPI) {
-	mockEnabled = true
+	mockMu.Lock()
+	defer mockMu.Unlock()
 	mockAthenaClientImpl = mockAthenaClient
 }
 
+// DisableMockMode undoes EnableMockMode. Databases opened before keep using
+// the mock.
+func DisableMockMode() {
+	mockMu.Lock()
+	defer mockMu.Unlock()
+	mockAthenaClientImpl = nil
+}
+
+func mockModeClient() athenaiface.AthenaAPI {
+	mockMu.RLock()
+	defer mockMu.RUnlock()
+	return mockAthenaClientImpl
+}
+
 // Driver is a sql.Driver. It's intended for db/sql.Open().
 type Driver struct {
 	cfg *Config
@@ -77,6 +100,28 @@ func init() {
 // Athena's API allows you to specify a data catalog for queries. This is the name of
 // the data catalog you want to use. If not specified, the default data catalog is used.
 //
+// - `loc` (optional)
+// The time zone, as an IANA name such as "America/New_York", in which `timestamp`
+// and `date` values without a time zone are interpreted. Defaults to "UTC".
+//
+// - `prefetch_depth` (optional)
+// The number of result pages fetched ahead in the background while the current
+// page is being consumed. Defaults to 0, which fetches each page on demand.
+//
+// - `page_size` (optional)
+// The number of rows fetched per GetQueryResults call, between 1 and 1000.
+// Defaults to 1000. It can be overridden per query with WithPageSize.
+//
+// - `page_retries` (optional)
+// How often fetching a result page is retried after a transient error, such as
+// throttling or a reset connection, before the error is returned. Defaults to 3,
+// a negative value disables retries.
+//
+// - `result_mode` (optional)
+// How query results are downloaded. "api" (the default) pages through them with
+// GetQueryResults, "s3" streams the CSV file Athena writes to the output location,
+// which is much faster for large results.
+//
 // - `region` (required)
 // Override AWS region. Useful if it is not set with environment variable.
 //
@@ -88,10 +133,45 @@ func init() {
 // Credentials must be accessible via the SDK's Default Credential Provider Chain.
 // For more advanced AWS credentials/session/config management, please supply
 // a custom AWS session directly via `athena.Open()`.
+//
+// - `endpoint` (optional)
+// The URL Athena and S3 requests are sent to instead of the AWS endpoints, e.g.
+// a local fake such as athenatest.Server. S3 is addressed path-style then.
+//
+// - `mock` (optional)
+// The path of a YAML fixture file whose query results are served by an in-memory
+// FakeAthena instead of Athena, so applications run offline in tests. The AWS
+// parameters aren't required then. "mock://path/to/fixtures.yaml" is a shorthand
+// for "mock=path/to/fixtures.yaml". The file lists queries, matched by their text
+// or a regular expression, with their result or failure:
+//
+//	queries:
+//	  - query: SELECT id, name FROM users WHERE id = ?
+//	    columns:
+//	      - {name: id, type: integer}
+//	      - {name: name, type: varchar}
+//	    rows:
+//	      - [1, vic]
+//	      - [2, null]
+//	    delay: 100ms
+//	  - regexp: ^DROP TABLE
+//	    error: "AccessDeniedException: not allowed"
 func (d *Driver) Open(connStr string) (driver.Conn, error) {
-	if mockEnabled {
-		return &conn{
-			athena: mockAthenaClientImpl,
+	connector, err := d.OpenConnector(connStr)
+	if err != nil {
+		return nil, err
+	}
+	return connector.Connect(context.Background())
+}
+
+// OpenConnector implements driver.DriverContext. It accepts the same
+// parameters as Open.
+func (d *Driver) OpenConnector(connStr string) (driver.Connector, error) {
+	if client := mockModeClient(); client != nil {
+		return &connector{
+			driver:  d,
+			athena:  client,
+			tracker: newQueryTracker(),
 		}, nil
 	}
 
@@ -103,27 +183,7 @@ func (d *Driver) Open(connStr string) (driver.Conn, error) {
 			return nil, err
 		}
 	}
-
-	if cfg.PollFrequency == 0 {
-		cfg.PollFrequency = defaultPollFrequency
-	}
-	if cfg.PollRetryIncrement == 0 {
-		cfg.PollRetryIncrement = defaultRetryDurationIncrement
-	}
-	if cfg.MaxRetryDuration == 0 {
-		cfg.MaxRetryDuration = defaultMaxRetryDuration
-	}
-
-	return &conn{
-		athena:             athena.New(cfg.Session),
-		db:                 cfg.Database,
-		OutputLocation:     cfg.OutputLocation,
-		pollFrequency:      cfg.PollFrequency,
-		pollRetryIncrement: cfg.PollRetryIncrement,
-		maxRetryDuration:   cfg.MaxRetryDuration,
-		workGroup:          cfg.WorkGroup,
-		dataCataLog:        cfg.DataCateLog,
-	}, nil
+	return newConnector(d, *cfg)
 }
 
 // Open is a more robust version of `db.Open`, as it accepts a raw aws.Session.
@@ -138,19 +198,15 @@ func Open(cfg Config) (*sql.DB, error) {
 		return nil, errors.New("s3_staging_url is required")
 	}
 
-	if cfg.Session == nil {
+	if cfg.Session == nil && cfg.Athena == nil {
 		return nil, errors.New("session is required")
 	}
 
-	// This hack was copied from jackc/pgx. Sorry :(
-	// https://github.com/jackc/pgx/blob/70a284f4f33a9cc28fd1223f6b83fb00deecfe33/stdlib/sql.go#L130-L136
-	openFromSessionMutex.Lock()
-	openFromSessionCount++
-	name := fmt.Sprintf("athena-%d", openFromSessionCount)
-	openFromSessionMutex.Unlock()
-
-	sql.Register(name, &Driver{&cfg})
-	return sql.Open(name, "")
+	connector, err := NewConnector(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return sql.OpenDB(connector), nil
 }
 
 // Config is the input to Open().
@@ -164,31 +220,84 @@ type Config struct {
 	MaxRetryDuration   time.Duration
 	WorkGroup          *string
 	DataCateLog        *string
+
+	// Location is the time zone zoneless timestamps and dates are
+	// interpreted in. Defaults to UTC.
+	Location *time.Location
+
+	// PrefetchDepth is the number of result pages fetched ahead in the
+	// background while rows are scanned. Zero fetches pages on demand.
+	PrefetchDepth int
+
+	// PageSize is the number of rows fetched per GetQueryResults call,
+	// between 1 and 1000. Defaults to 1000.
+	PageSize int64
+
+	// PageRetries is how often fetching a result page is retried after a
+	// transient error. Zero uses the default of 3, a negative value disables
+	// retries.
+	PageRetries int
+	// OnRetry is called before each retry.
+	OnRetry RetryHook
+
+	// ResultMode selects how query results are downloaded. Defaults to
+	// ResultModeAPI. ResultModeS3 fails queries unless there is an S3
+	// client.
+	ResultMode ResultMode
+	// S3 is the client used to download results in ResultModeS3 and with
+	// WithUnload. Defaults to a client created from Session.
+	S3 s3iface.S3API
+	// Athena is the client queries are run with. Defaults to a client
+	// created from Session. Set it to a FakeAthena or a mock in tests, in
+	// which case Session isn't required.
+	Athena athenaiface.AthenaAPI
 }
 
 func configFromConnectionString(connStr string) (*Config, error) {
+	var fixtures string
+	if rest, ok := strings.CutPrefix(connStr, mockDSNScheme); ok {
+		fixtures, connStr, _ = strings.Cut(rest, "?")
+	}
 	args, err := url.ParseQuery(connStr)
 	if err != nil {
 		return nil, err
 	}
+	if fixtures == "" {
+		fixtures = args.Get("mock")
+	}
 
 	var cfg Config
 
-	var acfg []*aws.Config
-	region := args.Get("region")
-	awsAccessKey := args.Get("aws_access_key")
-	awsAccessKeySecret := args.Get("aws_access_key_secret")
-	if region == "" || awsAccessKey == "" || awsAccessKeySecret == "" {
-		return nil, fmt.Errorf("region, aws_access_key and aws_access_key_secret are required")
-	}
+	if fixtures != "" {
+		cfg.Athena, err = loadMockFixtures(fixtures)
+		if err != nil {
+			return nil, err
+		}
+		cfg.PollFrequency = mockPollFrequency
+		cfg.PollRetryIncrement = mockPollFrequency
+		cfg.MaxRetryDuration = mockPollFrequency
+	} else {
+		var acfg []*aws.Config
+		region := args.Get("region")
+		awsAccessKey := args.Get("aws_access_key")
+		awsAccessKeySecret := args.Get("aws_access_key_secret")
+		if region == "" || awsAccessKey == "" || awsAccessKeySecret == "" {
+			return nil, fmt.Errorf("region, aws_access_key and aws_access_key_secret are required")
+		}
 
-	acfg = append(acfg, &aws.Config{
-		Region:      aws.String(region),
-		Credentials: credentials.NewStaticCredentials(awsAccessKey, awsAccessKeySecret, ""),
-	})
-	cfg.Session, err = session.NewSession(acfg...)
-	if err != nil {
-		return nil, err
+		awsCfg := &aws.Config{
+			Region:      aws.String(region),
+			Credentials: credentials.NewStaticCredentials(awsAccessKey, awsAccessKeySecret, ""),
+		}
+		if endpoint := args.Get("endpoint"); endpoint != "" {
+			awsCfg.Endpoint = aws.String(endpoint)
+			awsCfg.S3ForcePathStyle = aws.Bool(true)
+		}
+		acfg = append(acfg, awsCfg)
+		cfg.Session, err = session.NewSession(acfg...)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	cfg.Database = args.Get("db")
@@ -209,5 +318,40 @@ func configFromConnectionString(connStr string) (*Config, error) {
 	if dataCateLogStr != "" {
 		cfg.DataCateLog = aws.String(dataCateLogStr)
 	}
+	locStr := args.Get("loc")
+	if locStr != "" {
+		cfg.Location, err = time.LoadLocation(locStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid loc parameter: %s", locStr)
+		}
+	}
+	prefetchDepthStr := args.Get("prefetch_depth")
+	if prefetchDepthStr != "" {
+		cfg.PrefetchDepth, err = strconv.Atoi(prefetchDepthStr)
+		if err != nil || cfg.PrefetchDepth < 0 {
+			return nil, fmt.Errorf("invalid prefetch_depth parameter: %s", prefetchDepthStr)
+		}
+	}
+	pageSizeStr := args.Get("page_size")
+	if pageSizeStr != "" {
+		cfg.PageSize, err = strconv.ParseInt(pageSizeStr, 10, 64)
+		if err != nil || validatePageSize(cfg.PageSize) != nil {
+			return nil, fmt.Errorf("invalid page_size parameter: %s", pageSizeStr)
+		}
+	}
+	pageRetriesStr := args.Get("page_retries")
+	if pageRetriesStr != "" {
+		cfg.PageRetries, err = strconv.Atoi(pageRetriesStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid page_retries parameter: %s", pageRetriesStr)
+		}
+	}
+	resultModeStr := args.Get("result_mode")
+	switch ResultMode(resultModeStr) {
+	case "", ResultModeAPI, ResultModeS3:
+		cfg.ResultMode = ResultMode(resultModeStr)
+	default:
+		return nil, fmt.Errorf("invalid result_mode parameter: %s", resultModeStr)
+	}
 	return &cfg, nil
 }
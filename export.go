@@ -0,0 +1,385 @@
+package athena
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"github.com/apache/arrow/go/v15/arrow"
+	"github.com/apache/arrow/go/v15/arrow/array"
+	"github.com/apache/arrow/go/v15/arrow/memory"
+	"github.com/apache/arrow/go/v15/parquet"
+	"github.com/apache/arrow/go/v15/parquet/compress"
+	"github.com/apache/arrow/go/v15/parquet/pqarrow"
+)
+
+// ExportFormat is the file format Export writes.
+type ExportFormat string
+
+const (
+	// ExportFormatCSV writes RFC 4180 CSV.
+	ExportFormatCSV ExportFormat = "csv"
+	// ExportFormatJSONLines writes a JSON object per row, keyed by column
+	// name.
+	ExportFormatJSONLines ExportFormat = "jsonl"
+	// ExportFormatParquet writes a Parquet file with the Arrow schema
+	// QueryArrow reads the result into. Intervals, which Parquet has no
+	// type for, are written as strings in Athena's interval text.
+	ExportFormatParquet ExportFormat = "parquet"
+)
+
+// ExportOptions configures Export.
+type ExportOptions struct {
+	// Header writes the column names as the first CSV record.
+	Header bool
+	// NullValue is written for NULL values in CSV. Defaults to an empty
+	// field. JSON Lines always uses null.
+	NullValue string
+	// TimestampFormat is the Go time layout timestamps are written in.
+	// Defaults to TimestampLayout, followed by the zone for timestamps with
+	// a time zone.
+	TimestampFormat string
+	// Gzip compresses the output with gzip. Parquet files are compressed
+	// with Snappy by default and have their column chunks compressed with
+	// gzip instead.
+	Gzip bool
+	// Allocator allocates the Parquet buffers. Defaults to
+	// memory.DefaultAllocator.
+	Allocator memory.Allocator
+}
+
+// Export runs query on db and streams its result to w in format, encoding
+// each value according to the type of its column.
+func Export(ctx context.Context, db *sql.DB, query string, args []interface{}, w io.Writer, format ExportFormat, opts ExportOptions) error {
+	var newWriter func(io.Writer, []*sql.ColumnType, ExportOptions) exportWriter
+	switch format {
+	case ExportFormatCSV:
+		newWriter = newCSVExportWriter
+	case ExportFormatJSONLines:
+		newWriter = newJSONLinesExportWriter
+	case ExportFormatParquet:
+		newWriter = newParquetExportWriter
+	default:
+		return fmt.Errorf("unsupported export format: %s", format)
+	}
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	columns, err := rows.ColumnTypes()
+	if err != nil {
+		return err
+	}
+
+	var gz *gzip.Writer
+	if opts.Gzip && format != ExportFormatParquet {
+		gz = gzip.NewWriter(w)
+		w = gz
+	}
+	buf := bufio.NewWriter(w)
+	ew := newWriter(buf, columns, opts)
+	defer ew.close()
+	if err := ew.writeHeader(); err != nil {
+		return err
+	}
+
+	values := make([]interface{}, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+		if err := ew.writeRow(values); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if err := ew.flush(); err != nil {
+		return err
+	}
+	if err := buf.Flush(); err != nil {
+		return err
+	}
+	if gz != nil {
+		return gz.Close()
+	}
+	return nil
+}
+
+type exportWriter interface {
+	writeHeader() error
+	writeRow(values []interface{}) error
+	flush() error
+	// close releases the resources of the writer, flushed or not.
+	close()
+}
+
+type csvExportWriter struct {
+	w       *csv.Writer
+	columns []*sql.ColumnType
+	opts    ExportOptions
+	record  []string
+}
+
+func newCSVExportWriter(w io.Writer, columns []*sql.ColumnType, opts ExportOptions) exportWriter {
+	cw := csv.NewWriter(w)
+	cw.UseCRLF = true
+	return &csvExportWriter{w: cw, columns: columns, opts: opts, record: make([]string, len(columns))}
+}
+
+func (w *csvExportWriter) writeHeader() error {
+	if !w.opts.Header {
+		return nil
+	}
+	for i, column := range w.columns {
+		w.record[i] = column.Name()
+	}
+	return w.w.Write(w.record)
+}
+
+func (w *csvExportWriter) writeRow(values []interface{}) error {
+	for i, val := range values {
+		if val == nil {
+			w.record[i] = w.opts.NullValue
+			continue
+		}
+		w.record[i] = formatExportValue(w.columns[i].DatabaseTypeName(), val, w.opts)
+	}
+	return w.w.Write(w.record)
+}
+
+func (w *csvExportWriter) flush() error {
+	w.w.Flush()
+	return w.w.Error()
+}
+
+func (w *csvExportWriter) close() {}
+
+type jsonLinesExportWriter struct {
+	w       io.Writer
+	columns []*sql.ColumnType
+	keys    [][]byte
+	opts    ExportOptions
+	line    []byte
+}
+
+func newJSONLinesExportWriter(w io.Writer, columns []*sql.ColumnType, opts ExportOptions) exportWriter {
+	keys := make([][]byte, len(columns))
+	for i, column := range columns {
+		keys[i], _ = json.Marshal(column.Name())
+	}
+	return &jsonLinesExportWriter{w: w, columns: columns, keys: keys, opts: opts}
+}
+
+func (w *jsonLinesExportWriter) writeHeader() error {
+	return nil
+}
+
+func (w *jsonLinesExportWriter) writeRow(values []interface{}) error {
+	// the object is written by hand to keep the columns in order
+	line := append(w.line[:0], '{')
+	for i, val := range values {
+		if i > 0 {
+			line = append(line, ',')
+		}
+		line = append(line, w.keys[i]...)
+		line = append(line, ':')
+
+		encoded, err := w.encode(w.columns[i].DatabaseTypeName(), val)
+		if err != nil {
+			return fmt.Errorf("column %s: %w", w.columns[i].Name(), err)
+		}
+		line = append(line, encoded...)
+	}
+	line = append(line, '}', '\n')
+	w.line = line
+
+	_, err := w.w.Write(line)
+	return err
+}
+
+func (w *jsonLinesExportWriter) encode(athenaType string, val interface{}) ([]byte, error) {
+	switch v := val.(type) {
+	case nil:
+		return []byte("null"), nil
+	case int64:
+		if normalizeType(athenaType) == "interval year to month" {
+			return json.Marshal(formatExportValue(athenaType, v, w.opts))
+		}
+		return json.Marshal(v)
+	case bool:
+		return json.Marshal(v)
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return json.Marshal(formatExportValue(athenaType, v, w.opts))
+		}
+		return json.Marshal(v)
+	case string:
+		if isDecimalType(athenaType) {
+			// decimals are written as numbers without losing precision
+			return []byte(v), nil
+		}
+		return json.Marshal(v)
+	case []byte:
+		if normalizeType(athenaType) == "json" && json.Valid(v) {
+			return v, nil
+		}
+		return json.Marshal(formatExportValue(athenaType, v, w.opts))
+	default:
+		return json.Marshal(formatExportValue(athenaType, v, w.opts))
+	}
+}
+
+func (w *jsonLinesExportWriter) flush() error {
+	return nil
+}
+
+func (w *jsonLinesExportWriter) close() {}
+
+type parquetExportWriter struct {
+	w       io.Writer
+	columns []*sql.ColumnType
+	opts    ExportOptions
+
+	types   []typeSignature
+	fw      *pqarrow.FileWriter
+	builder *array.RecordBuilder
+	rows    int
+}
+
+func newParquetExportWriter(w io.Writer, columns []*sql.ColumnType, opts ExportOptions) exportWriter {
+	if opts.Allocator == nil {
+		opts.Allocator = memory.DefaultAllocator
+	}
+	return &parquetExportWriter{w: w, columns: columns, opts: opts}
+}
+
+// writeHeader starts the file with the schema of the columns.
+func (w *parquetExportWriter) writeHeader() error {
+	w.types = make([]typeSignature, len(w.columns))
+	fields := make([]arrow.Field, len(w.columns))
+	for i, column := range w.columns {
+		sig, err := parseTypeSignature(column.DatabaseTypeName())
+		if err != nil {
+			return fmt.Errorf("column %s: %w", column.Name(), err)
+		}
+		w.types[i] = sig
+		fields[i] = arrow.Field{Name: column.Name(), Type: parquetArrowType(sig.arrowType()), Nullable: true}
+	}
+	schema := arrow.NewSchema(fields, nil)
+
+	codec := compress.Codecs.Snappy
+	if w.opts.Gzip {
+		codec = compress.Codecs.Gzip
+	}
+	fw, err := pqarrow.NewFileWriter(schema, w.w,
+		parquet.NewWriterProperties(parquet.WithCompression(codec), parquet.WithAllocator(w.opts.Allocator)),
+		pqarrow.NewArrowWriterProperties(pqarrow.WithAllocator(w.opts.Allocator)))
+	if err != nil {
+		return err
+	}
+	w.fw = fw
+	w.builder = array.NewRecordBuilder(w.opts.Allocator, schema)
+	return nil
+}
+
+// parquetArrowType replaces the interval types of dt, which Parquet can't
+// store, with strings.
+func parquetArrowType(dt arrow.DataType) arrow.DataType {
+	switch dt := dt.(type) {
+	case *arrow.MonthIntervalType, *arrow.DurationType:
+		return arrow.BinaryTypes.String
+	case *arrow.ListType:
+		return arrow.ListOf(parquetArrowType(dt.Elem()))
+	case *arrow.MapType:
+		return arrow.MapOf(parquetArrowType(dt.KeyType()), parquetArrowType(dt.ItemType()))
+	case *arrow.StructType:
+		fields := make([]arrow.Field, len(dt.Fields()))
+		for i, field := range dt.Fields() {
+			field.Type = parquetArrowType(field.Type)
+			fields[i] = field
+		}
+		return arrow.StructOf(fields...)
+	default:
+		return dt
+	}
+}
+
+func (w *parquetExportWriter) writeRow(values []interface{}) error {
+	for i, val := range values {
+		text, err := formatValue(w.columns[i].DatabaseTypeName(), val)
+		if err != nil {
+			return fmt.Errorf("column %s: %w", w.columns[i].Name(), err)
+		}
+		if err := appendArrowValue(w.builder.Field(i), w.types[i], text); err != nil {
+			return fmt.Errorf("column %s: %w", w.columns[i].Name(), err)
+		}
+	}
+	w.rows++
+	if w.rows < parquetBatchSize {
+		return nil
+	}
+	return w.writeRecord()
+}
+
+// writeRecord writes the rows built so far.
+func (w *parquetExportWriter) writeRecord() error {
+	rec := w.builder.NewRecord()
+	defer rec.Release()
+	w.rows = 0
+	return w.fw.WriteBuffered(rec)
+}
+
+func (w *parquetExportWriter) flush() error {
+	if w.rows > 0 {
+		if err := w.writeRecord(); err != nil {
+			return err
+		}
+	}
+	fw := w.fw
+	w.fw = nil
+	return fw.Close()
+}
+
+// close releases the builder and closes the file if it wasn't flushed, e.g.
+// as writing a row failed.
+func (w *parquetExportWriter) close() {
+	if w.builder != nil {
+		w.builder.Release()
+		w.builder = nil
+	}
+	if w.fw != nil {
+		w.fw.Close()
+		w.fw = nil
+	}
+}
+
+// formatExportValue formats a non-NULL value of a column of athenaType as
+// text, the way Athena renders it unless opts say otherwise.
+func formatExportValue(athenaType string, val interface{}, opts ExportOptions) string {
+	if t, ok := val.(time.Time); ok && opts.TimestampFormat != "" {
+		if typ := normalizeType(athenaType); typ == "timestamp" || typ == "timestamp with time zone" {
+			return t.Format(opts.TimestampFormat)
+		}
+	}
+	text, err := formatValue(athenaType, val)
+	if err != nil || text == nil {
+		return fmt.Sprint(val)
+	}
+	return *text
+}
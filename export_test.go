@@ -0,0 +1,171 @@
+package athena
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/apache/arrow/go/v15/arrow/array"
+	"github.com/apache/arrow/go/v15/arrow/memory"
+	"github.com/apache/arrow/go/v15/parquet/compress"
+	"github.com/apache/arrow/go/v15/parquet/file"
+	"github.com/apache/arrow/go/v15/parquet/pqarrow"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/vx416/go-athenav/athenamock"
+)
+
+// mockExportDB returns a db whose queries return a row of every exported type
+// and a row of NULLs.
+func mockExportDB(t *testing.T) *sql.DB {
+	columns := []*athena.ColumnInfo{
+		{Name: aws.String("id"), Type: aws.String("bigint")},
+		{Name: aws.String("name"), Type: aws.String("varchar")},
+		{Name: aws.String("price"), Type: aws.String("decimal(10,2)")},
+		{Name: aws.String("ratio"), Type: aws.String("double")},
+		{Name: aws.String("ok"), Type: aws.String("boolean")},
+		{Name: aws.String("day"), Type: aws.String("date")},
+		{Name: aws.String("at"), Type: aws.String("timestamp")},
+		{Name: aws.String("doc"), Type: aws.String("json")},
+		{Name: aws.String("bin"), Type: aws.String("varbinary")},
+		{Name: aws.String("span"), Type: aws.String("interval year to month")},
+		{Name: aws.String("gap"), Type: aws.String("interval day to second")},
+	}
+	header := &athena.Row{}
+	values := &athena.Row{}
+	nulls := &athena.Row{}
+	for i, val := range []string{"1", "a \"quoted\", name", "10.50", "0.25", "true", "2024-01-02", "2024-01-02 03:04:05.123", `{"k":[1,2]}`, "68 69", "-1-2", "2 03:04:05.500"} {
+		header.Data = append(header.Data, &athena.Datum{VarCharValue: columns[i].Name})
+		values.Data = append(values.Data, &athena.Datum{VarCharValue: aws.String(val)})
+		nulls.Data = append(nulls.Data, &athena.Datum{})
+	}
+
+	mockAPI := athenamock.AthenaAPI{}
+	mockAPI.On("StartQueryExecution", mock.Anything).Return(&athena.StartQueryExecutionOutput{QueryExecutionId: aws.String("export")}, nil)
+	mockAPI.On("GetQueryExecutionWithContext", mock.Anything, mock.Anything).Return(&athena.GetQueryExecutionOutput{QueryExecution: &athena.QueryExecution{
+		Status: &athena.QueryExecutionStatus{State: aws.String(athena.QueryExecutionStateSucceeded)},
+	}}, nil)
+	mockAPI.On("GetQueryResultsWithContext", mock.Anything, mock.Anything).Return(func(aws.Context, *athena.GetQueryResultsInput, ...request.Option) *athena.GetQueryResultsOutput {
+		return &athena.GetQueryResultsOutput{ResultSet: &athena.ResultSet{
+			ResultSetMetadata: &athena.ResultSetMetadata{ColumnInfo: columns},
+			Rows:              []*athena.Row{header, values, nulls},
+		}}
+	}, nil)
+	return openMockDB(t, &mockAPI)
+}
+
+func TestExport_CSV(t *testing.T) {
+	db := mockExportDB(t)
+
+	var buf bytes.Buffer
+	err := Export(context.Background(), db, "SELECT * FROM t", nil, &buf, ExportFormatCSV, ExportOptions{
+		Header:          true,
+		NullValue:       `\N`,
+		TimestampFormat: "2006-01-02T15:04:05.000Z07:00",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "id,name,price,ratio,ok,day,at,doc,bin,span,gap\r\n"+
+		"1,\"a \"\"quoted\"\", name\",10.50,0.25,true,2024-01-02,2024-01-02T03:04:05.123Z,\"{\"\"k\"\":[1,2]}\",6869,-1-2,2 03:04:05.500\r\n"+
+		`\N,\N,\N,\N,\N,\N,\N,\N,\N,\N,\N`+"\r\n", buf.String())
+}
+
+func TestExport_JSONLinesGzip(t *testing.T) {
+	db := mockExportDB(t)
+
+	var buf bytes.Buffer
+	err := Export(context.Background(), db, "SELECT * FROM t", nil, &buf, ExportFormatJSONLines, ExportOptions{Gzip: true})
+	require.NoError(t, err)
+
+	gz, err := gzip.NewReader(&buf)
+	require.NoError(t, err)
+	out, err := io.ReadAll(gz)
+	require.NoError(t, err)
+	assert.Equal(t, `{"id":1,"name":"a \"quoted\", name","price":10.50,"ratio":0.25,"ok":true,"day":"2024-01-02","at":"2024-01-02 03:04:05.123","doc":{"k":[1,2]},"bin":"6869","span":"-1-2","gap":"2 03:04:05.500"}`+"\n"+
+		`{"id":null,"name":null,"price":null,"ratio":null,"ok":null,"day":null,"at":null,"doc":null,"bin":null,"span":null,"gap":null}`+"\n", string(out))
+}
+
+func TestExport_Parquet(t *testing.T) {
+	db := mockExportDB(t)
+
+	var buf bytes.Buffer
+	err := Export(context.Background(), db, "SELECT * FROM t", nil, &buf, ExportFormatParquet, ExportOptions{Gzip: true})
+	require.NoError(t, err)
+
+	pf, err := file.NewParquetReader(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	chunk, err := pf.MetaData().RowGroup(0).ColumnChunk(0)
+	require.NoError(t, err)
+	assert.Equal(t, compress.Codecs.Gzip, chunk.Compression())
+	fr, err := pqarrow.NewFileReader(pf, pqarrow.ArrowReadProperties{}, memory.DefaultAllocator)
+	require.NoError(t, err)
+	table, err := fr.ReadTable(context.Background())
+	require.NoError(t, err)
+	defer table.Release()
+
+	require.EqualValues(t, 2, table.NumRows())
+	var (
+		types  []string
+		values []string
+	)
+	for i := 0; i < int(table.NumCols()); i++ {
+		column := table.Column(i)
+		types = append(types, column.DataType().String())
+		chunk := column.Data().Chunk(0)
+		values = append(values, chunk.ValueStr(0))
+		assert.True(t, chunk.IsNull(1), column.Name())
+	}
+	assert.Equal(t, []string{"int64", "utf8", "decimal(10, 2)", "float64", "bool", "date32", "timestamp[ms, tz=UTC]", "utf8", "binary", "utf8", "utf8"}, types)
+	assert.Equal(t, []string{"1", `a "quoted", name`, "10.5", "0.25", "true", "2024-01-02", "2024-01-02 03:04:05.123Z", `{"k":[1,2]}`, "aGk=", "-1-2", "2 03:04:05.500"}, values)
+}
+
+func TestExport_TimestampPrecision(t *testing.T) {
+	mockAPI := athenamock.AthenaAPI{}
+	MockQuery(&mockAPI, []string{"at", "zoned"}, []string{"timestamp(6)", "timestamp(9) with time zone"},
+		[][]string{{"2024-01-02 03:04:05.123456", "2024-01-02 03:04:05.000000001 UTC"}})
+
+	var buf bytes.Buffer
+	err := Export(context.Background(), openMockDB(t, &mockAPI), "SELECT * FROM t", nil, &buf, ExportFormatCSV, ExportOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "2024-01-02 03:04:05.123456,2024-01-02 03:04:05.000000001 UTC\r\n", buf.String())
+
+	buf.Reset()
+	err = Export(context.Background(), openMockDB(t, &mockAPI), "SELECT * FROM t", nil, &buf, ExportFormatParquet, ExportOptions{})
+	require.NoError(t, err)
+	pf, err := file.NewParquetReader(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	fr, err := pqarrow.NewFileReader(pf, pqarrow.ArrowReadProperties{}, memory.DefaultAllocator)
+	require.NoError(t, err)
+	table, err := fr.ReadTable(context.Background())
+	require.NoError(t, err)
+	defer table.Release()
+	at := table.Column(0).Data().Chunk(0).(*array.Timestamp)
+	assert.Equal(t, time.Date(2024, 1, 2, 3, 4, 5, 123456000, time.UTC).UnixMicro(), int64(at.Value(0)))
+	zoned := table.Column(1).Data().Chunk(0).(*array.Timestamp)
+	assert.Equal(t, int64(time.Date(2024, 1, 2, 3, 4, 5, 1, time.UTC).UnixNano()), int64(zoned.Value(0)))
+}
+
+func TestExport_ParquetAbort(t *testing.T) {
+	mockAPI := athenamock.AthenaAPI{}
+	MockQuery(&mockAPI, []string{"id", "name"}, []string{"integer", "varchar"},
+		[][]string{{"1", "a"}, {"2", "b"}}, MockPageSize(2), MockPageError(2, errors.New("page failed")))
+	db := openMockDB(t, &mockAPI)
+
+	mem := memory.NewCheckedAllocator(memory.NewGoAllocator())
+	err := Export(context.Background(), db, "SELECT * FROM t", nil, io.Discard, ExportFormatParquet, ExportOptions{Allocator: mem})
+	require.EqualError(t, err, "page failed")
+	mem.AssertSize(t, 0)
+}
+
+func TestExport_UnsupportedFormat(t *testing.T) {
+	err := Export(context.Background(), nil, "SELECT 1", nil, io.Discard, "orc", ExportOptions{})
+	assert.EqualError(t, err, "unsupported export format: orc")
+}
@@ -0,0 +1,375 @@
+package athena
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/aws/aws-sdk-go/service/athena/athenaiface"
+)
+
+// FakeColumn is a column of a FakeResult.
+type FakeColumn struct {
+	Name string
+	// Type is the Athena type, e.g. "varchar" or "decimal(10,2)".
+	Type string
+}
+
+// FakeResult is what a FakeAthena query routed to it returns.
+type FakeResult struct {
+	Columns []FakeColumn
+	// Rows holds the values of each row. nil is NULL, strings are returned
+	// as is and other values are formatted the way Athena renders values of
+	// the column type.
+	Rows [][]interface{}
+
+	// StatementType is the statement type of the query. Defaults to DML,
+	// whose results start with a header row.
+	StatementType string
+	// SubstatementType is the substatement type, e.g. "SELECT".
+	SubstatementType string
+
+	// QueuedPolls and RunningPolls are how many GetQueryExecution calls
+	// report the query as QUEUED and then RUNNING before it finishes.
+	QueuedPolls  int
+	RunningPolls int
+	// Delay keeps the query RUNNING until it passed since the query started.
+	Delay time.Duration
+	// FailureReason makes the query FAILED with it as state change reason.
+	FailureReason string
+	// Cancelled makes the query CANCELLED.
+	Cancelled bool
+}
+
+// FakeQuery is a query started on a FakeAthena.
+type FakeQuery struct {
+	ID    string
+	Query string
+	// Params are the execution parameters of the query.
+	Params         []string
+	Database       string
+	Catalog        string
+	WorkGroup      string
+	OutputLocation string
+	// State is the current state of the query.
+	State string
+	// Stopped reports whether StopQueryExecution was called for the query.
+	Stopped bool
+
+	result  *FakeResult
+	polls   int
+	started time.Time
+}
+
+type fakeRoute struct {
+	query   string
+	pattern *regexp.Regexp
+	result  *FakeResult
+}
+
+// FakeAthena is an in-memory Athena backend for tests. Queries are routed to
+// the results registered with Register and RegisterRegexp, and move through
+// the QUEUED, RUNNING and SUCCEEDED, FAILED or CANCELLED states as their
+// execution is polled. Set it as Config.Athena to use it.
+//
+// Only the methods used by the driver are implemented, calling other ones
+// panics.
+type FakeAthena struct {
+	athenaiface.AthenaAPI
+
+	mu      sync.Mutex
+	routes  []fakeRoute
+	queries map[string]*FakeQuery
+	order   []string
+}
+
+// NewFakeAthena returns a FakeAthena without registered results.
+func NewFakeAthena() *FakeAthena {
+	return &FakeAthena{queries: make(map[string]*FakeQuery)}
+}
+
+// Register routes queries whose text is query, ignoring surrounding
+// whitespace, to result.
+func (f *FakeAthena) Register(query string, result FakeResult) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.routes = append(f.routes, fakeRoute{query: strings.TrimSpace(query), result: &result})
+}
+
+// RegisterRegexp routes queries matching pattern to result. Exact routes
+// registered with Register take precedence, then patterns are tried in
+// registration order.
+func (f *FakeAthena) RegisterRegexp(pattern string, result FakeResult) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.routes = append(f.routes, fakeRoute{pattern: regexp.MustCompile(pattern), result: &result})
+}
+
+// Queries returns the queries started so far, in start order.
+func (f *FakeAthena) Queries() []FakeQuery {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	queries := make([]FakeQuery, 0, len(f.order))
+	for _, id := range f.order {
+		queries = append(queries, *f.queries[id])
+	}
+	return queries
+}
+
+func (f *FakeAthena) route(query string) *FakeResult {
+	query = strings.TrimSpace(query)
+	for _, r := range f.routes {
+		if r.pattern == nil && r.query == query {
+			return r.result
+		}
+	}
+	for _, r := range f.routes {
+		if r.pattern != nil && r.pattern.MatchString(query) {
+			return r.result
+		}
+	}
+	return nil
+}
+
+func (f *FakeAthena) StartQueryExecution(input *athena.StartQueryExecutionInput) (*athena.StartQueryExecutionOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	queryString := aws.StringValue(input.QueryString)
+	result := f.route(queryString)
+	if result == nil {
+		return nil, awserr.New(athena.ErrCodeInvalidRequestException,
+			fmt.Sprintf("no fake result registered for query: %s", queryString), nil)
+	}
+
+	q := &FakeQuery{
+		ID:        fmt.Sprintf("fake-query-%d", len(f.order)+1),
+		Query:     queryString,
+		Params:    aws.StringValueSlice(input.ExecutionParameters),
+		WorkGroup: aws.StringValue(input.WorkGroup),
+		result:    result,
+		started:   time.Now(),
+	}
+	if input.QueryExecutionContext != nil {
+		q.Database = aws.StringValue(input.QueryExecutionContext.Database)
+		q.Catalog = aws.StringValue(input.QueryExecutionContext.Catalog)
+	}
+	if input.ResultConfiguration != nil {
+		q.OutputLocation = aws.StringValue(input.ResultConfiguration.OutputLocation)
+	}
+	q.State = q.stateAt(0)
+
+	f.queries[q.ID] = q
+	f.order = append(f.order, q.ID)
+	return &athena.StartQueryExecutionOutput{QueryExecutionId: aws.String(q.ID)}, nil
+}
+
+func (f *FakeAthena) StartQueryExecutionWithContext(_ aws.Context, input *athena.StartQueryExecutionInput, _ ...request.Option) (*athena.StartQueryExecutionOutput, error) {
+	return f.StartQueryExecution(input)
+}
+
+// stateAt returns the state of the query at its polls-th poll.
+func (q *FakeQuery) stateAt(polls int) string {
+	switch r := q.result; {
+	case polls < r.QueuedPolls:
+		return athena.QueryExecutionStateQueued
+	case polls < r.QueuedPolls+r.RunningPolls || time.Since(q.started) < r.Delay:
+		return athena.QueryExecutionStateRunning
+	case r.FailureReason != "":
+		return athena.QueryExecutionStateFailed
+	case r.Cancelled:
+		return athena.QueryExecutionStateCancelled
+	default:
+		return athena.QueryExecutionStateSucceeded
+	}
+}
+
+func isFinalState(state string) bool {
+	switch state {
+	case athena.QueryExecutionStateSucceeded, athena.QueryExecutionStateFailed, athena.QueryExecutionStateCancelled:
+		return true
+	}
+	return false
+}
+
+func (f *FakeAthena) lookup(queryID *string) (*FakeQuery, error) {
+	q, ok := f.queries[aws.StringValue(queryID)]
+	if !ok {
+		return nil, awserr.New(athena.ErrCodeInvalidRequestException,
+			fmt.Sprintf("QueryExecution %s was not found", aws.StringValue(queryID)), nil)
+	}
+	return q, nil
+}
+
+func (f *FakeAthena) GetQueryExecution(input *athena.GetQueryExecutionInput) (*athena.GetQueryExecutionOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	q, err := f.lookup(input.QueryExecutionId)
+	if err != nil {
+		return nil, err
+	}
+	if !isFinalState(q.State) {
+		q.State = q.stateAt(q.polls)
+		q.polls++
+	}
+
+	statementType := q.result.StatementType
+	if statementType == "" {
+		statementType = athena.StatementTypeDml
+	}
+	exec := &athena.QueryExecution{
+		QueryExecutionId: aws.String(q.ID),
+		Query:            aws.String(q.Query),
+		StatementType:    aws.String(statementType),
+		Status:           &athena.QueryExecutionStatus{State: aws.String(q.State)},
+		QueryExecutionContext: &athena.QueryExecutionContext{
+			Database: aws.String(q.Database),
+			Catalog:  aws.String(q.Catalog),
+		},
+		WorkGroup:           aws.String(q.WorkGroup),
+		ExecutionParameters: aws.StringSlice(q.Params),
+	}
+	if q.result.SubstatementType != "" {
+		exec.SubstatementType = aws.String(q.result.SubstatementType)
+	}
+	if q.OutputLocation != "" {
+		exec.ResultConfiguration = &athena.ResultConfiguration{
+			OutputLocation: aws.String(strings.TrimSuffix(q.OutputLocation, "/") + "/" + q.ID + ".csv"),
+		}
+	}
+	switch q.State {
+	case athena.QueryExecutionStateFailed:
+		exec.Status.StateChangeReason = aws.String(q.result.FailureReason)
+	case athena.QueryExecutionStateCancelled:
+		if q.Stopped {
+			exec.Status.StateChangeReason = aws.String("Query cancelled by user")
+		}
+	}
+	return &athena.GetQueryExecutionOutput{QueryExecution: exec}, nil
+}
+
+func (f *FakeAthena) GetQueryExecutionWithContext(_ aws.Context, input *athena.GetQueryExecutionInput, _ ...request.Option) (*athena.GetQueryExecutionOutput, error) {
+	return f.GetQueryExecution(input)
+}
+
+func (f *FakeAthena) StopQueryExecution(input *athena.StopQueryExecutionInput) (*athena.StopQueryExecutionOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	q, err := f.lookup(input.QueryExecutionId)
+	if err != nil {
+		return nil, err
+	}
+	q.Stopped = true
+	if !isFinalState(q.State) {
+		q.State = athena.QueryExecutionStateCancelled
+	}
+	return &athena.StopQueryExecutionOutput{}, nil
+}
+
+func (f *FakeAthena) StopQueryExecutionWithContext(_ aws.Context, input *athena.StopQueryExecutionInput, _ ...request.Option) (*athena.StopQueryExecutionOutput, error) {
+	return f.StopQueryExecution(input)
+}
+
+func (f *FakeAthena) GetQueryResults(input *athena.GetQueryResultsInput) (*athena.GetQueryResultsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	q, err := f.lookup(input.QueryExecutionId)
+	if err != nil {
+		return nil, err
+	}
+	if q.State != athena.QueryExecutionStateSucceeded {
+		return nil, awserr.New(athena.ErrCodeInvalidRequestException,
+			fmt.Sprintf("Query has not yet finished. Current query state: %s", q.State), nil)
+	}
+
+	rows, err := q.result.athenaRows()
+	if err != nil {
+		return nil, err
+	}
+	start := 0
+	if input.NextToken != nil {
+		if start, err = strconv.Atoi(*input.NextToken); err != nil || start < 0 || start > len(rows) {
+			return nil, awserr.New(athena.ErrCodeInvalidRequestException, "invalid NextToken", nil)
+		}
+	}
+	maxResults := int(aws.Int64Value(input.MaxResults))
+	if maxResults <= 0 || maxResults > maxResultCnt {
+		maxResults = maxResultCnt
+	}
+	end := min(start+maxResults, len(rows))
+
+	out := &athena.GetQueryResultsOutput{
+		ResultSet: &athena.ResultSet{
+			ResultSetMetadata: &athena.ResultSetMetadata{ColumnInfo: q.result.columnInfo()},
+			Rows:              rows[start:end],
+		},
+	}
+	if end < len(rows) {
+		out.NextToken = aws.String(strconv.Itoa(end))
+	}
+	return out, nil
+}
+
+func (f *FakeAthena) GetQueryResultsWithContext(_ aws.Context, input *athena.GetQueryResultsInput, _ ...request.Option) (*athena.GetQueryResultsOutput, error) {
+	return f.GetQueryResults(input)
+}
+
+func (r *FakeResult) columnInfo() []*athena.ColumnInfo {
+	columns := make([]*athena.ColumnInfo, 0, len(r.Columns))
+	for _, column := range r.Columns {
+		colInfo := &athena.ColumnInfo{
+			Name:     aws.String(column.Name),
+			Label:    aws.String(column.Name),
+			Type:     aws.String(column.Type),
+			Nullable: aws.String(athena.ColumnNullableUnknown),
+		}
+		if sig, err := parseTypeSignature(column.Type); err == nil && len(sig.args) > 0 {
+			colInfo.Precision = aws.Int64(sig.args[0])
+			if len(sig.args) > 1 {
+				colInfo.Scale = aws.Int64(sig.args[1])
+			}
+		}
+		columns = append(columns, colInfo)
+	}
+	return columns
+}
+
+// athenaRows renders the rows of the result, preceded by the header row for
+// DML statements.
+func (r *FakeResult) athenaRows() ([]*athena.Row, error) {
+	rows := make([]*athena.Row, 0, len(r.Rows)+1)
+	if r.StatementType == "" || r.StatementType == athena.StatementTypeDml {
+		header := &athena.Row{}
+		for _, column := range r.Columns {
+			header.Data = append(header.Data, &athena.Datum{VarCharValue: aws.String(column.Name)})
+		}
+		rows = append(rows, header)
+	}
+	for _, values := range r.Rows {
+		if len(values) != len(r.Columns) {
+			return nil, fmt.Errorf("fake row has %d values for %d columns", len(values), len(r.Columns))
+		}
+		row := &athena.Row{}
+		for i, val := range values {
+			text, err := formatValue(r.Columns[i].Type, val)
+			if err != nil {
+				return nil, fmt.Errorf("column %s: %w", r.Columns[i].Name, err)
+			}
+			row.Data = append(row.Data, &athena.Datum{VarCharValue: text})
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+var _ athenaiface.AthenaAPI = (*FakeAthena)(nil)
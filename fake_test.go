@@ -0,0 +1,141 @@
+package athena
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFakeAthena_Routing(t *testing.T) {
+	fake := NewFakeAthena()
+	fake.Register("SELECT 1", FakeResult{
+		Columns: []FakeColumn{{Name: "one", Type: "integer"}},
+		Rows:    [][]interface{}{{1}},
+	})
+	fake.RegisterRegexp(`^SELECT name, joined FROM users WHERE id = \?$`, FakeResult{
+		Columns: []FakeColumn{{Name: "name", Type: "varchar"}, {Name: "joined", Type: "date"}},
+		Rows: [][]interface{}{
+			{"vic", time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)},
+			{nil, nil},
+		},
+		RunningPolls: 2,
+	})
+	db := openMockDB(t, fake)
+
+	var one int
+	require.NoError(t, db.QueryRow(" SELECT 1 ").Scan(&one))
+	assert.Equal(t, 1, one)
+
+	rows, err := db.Query("SELECT name, joined FROM users WHERE id = ?", 42)
+	require.NoError(t, err)
+	var (
+		names  []*string
+		joined []*time.Time
+	)
+	for rows.Next() {
+		var name *string
+		var day *time.Time
+		require.NoError(t, rows.Scan(&name, &day))
+		names = append(names, name)
+		joined = append(joined, day)
+	}
+	require.NoError(t, rows.Err())
+	assert.Equal(t, []*string{aws.String("vic"), nil}, names)
+	assert.Equal(t, time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC), *joined[0])
+	assert.Nil(t, joined[1])
+
+	_, err = db.Query("SELECT 2")
+	assert.ErrorContains(t, err, "no fake result registered for query: SELECT 2")
+
+	queries := fake.Queries()
+	require.Len(t, queries, 2)
+	assert.Equal(t, "fake-query-1", queries[0].ID)
+	assert.Equal(t, "fake-query-2", queries[1].ID)
+	assert.Equal(t, []string{"42"}, queries[1].Params)
+	assert.Equal(t, athena.QueryExecutionStateSucceeded, queries[1].State)
+}
+
+func TestFakeAthena_States(t *testing.T) {
+	fake := NewFakeAthena()
+	fake.Register("SELECT slow", FakeResult{QueuedPolls: 1, RunningPolls: 2})
+	fake.Register("SELECT broken", FakeResult{FailureReason: "SYNTAX_ERROR: line 1:8"})
+	fake.Register("SELECT cancelled", FakeResult{Cancelled: true})
+
+	start := func(query string) *string {
+		out, err := fake.StartQueryExecution(&athena.StartQueryExecutionInput{QueryString: aws.String(query)})
+		require.NoError(t, err)
+		return out.QueryExecutionId
+	}
+	state := func(id *string) string {
+		out, err := fake.GetQueryExecution(&athena.GetQueryExecutionInput{QueryExecutionId: id})
+		require.NoError(t, err)
+		return *out.QueryExecution.Status.State
+	}
+
+	slow := start("SELECT slow")
+	_, err := fake.GetQueryResults(&athena.GetQueryResultsInput{QueryExecutionId: slow})
+	assert.ErrorContains(t, err, "Query has not yet finished")
+	var states []string
+	for i := 0; i < 5; i++ {
+		states = append(states, state(slow))
+	}
+	assert.Equal(t, []string{"QUEUED", "RUNNING", "RUNNING", "SUCCEEDED", "SUCCEEDED"}, states)
+
+	assert.Equal(t, athena.QueryExecutionStateFailed, state(start("SELECT broken")))
+	assert.Equal(t, athena.QueryExecutionStateCancelled, state(start("SELECT cancelled")))
+
+	stopped := start("SELECT slow")
+	_, err = fake.StopQueryExecution(&athena.StopQueryExecutionInput{QueryExecutionId: stopped})
+	require.NoError(t, err)
+	assert.Equal(t, athena.QueryExecutionStateCancelled, state(stopped))
+	assert.True(t, fake.Queries()[3].Stopped)
+
+	db := openMockDB(t, fake)
+	_, err = db.Query("SELECT broken")
+	assert.EqualError(t, err, "SYNTAX_ERROR: line 1:8")
+}
+
+func TestFakeAthena_Paging(t *testing.T) {
+	fake := NewFakeAthena()
+	result := FakeResult{Columns: []FakeColumn{{Name: "n", Type: "bigint"}}}
+	for i := 1; i <= 5; i++ {
+		result.Rows = append(result.Rows, []interface{}{i})
+	}
+	fake.Register("SELECT n FROM t", result)
+	db := openMockDB(t, fake)
+
+	rows, err := db.QueryContext(WithPageSize(context.Background(), 2), "SELECT n FROM t")
+	require.NoError(t, err)
+	var got []int
+	for rows.Next() {
+		var n int
+		require.NoError(t, rows.Scan(&n))
+		got = append(got, n)
+	}
+	require.NoError(t, rows.Err())
+	assert.Equal(t, []int{1, 2, 3, 4, 5}, got)
+}
+
+func TestFakeAthena_ColumnTypes(t *testing.T) {
+	fake := NewFakeAthena()
+	fake.Register("SELECT price FROM t", FakeResult{
+		Columns: []FakeColumn{{Name: "price", Type: "decimal(10,2)"}},
+		Rows:    [][]interface{}{{"10.50"}},
+	})
+	db := openMockDB(t, fake)
+
+	rows, err := db.Query("SELECT price FROM t")
+	require.NoError(t, err)
+	defer rows.Close()
+	types, err := rows.ColumnTypes()
+	require.NoError(t, err)
+	assert.Equal(t, "decimal(10,2)", types[0].DatabaseTypeName())
+	precision, scale, ok := types[0].DecimalSize()
+	assert.True(t, ok)
+	assert.Equal(t, []int64{10, 2}, []int64{precision, scale})
+}
@@ -0,0 +1,161 @@
+// Package athenafmt renders Go values the way Athena renders them in query
+// results. It is shared by the driver and athenamock, which can't import the
+// driver as the driver's tests import it.
+package athenafmt
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cast"
+)
+
+const (
+	// TimestampLayout is the Go time layout string for an Athena `timestamp`.
+	TimestampLayout = "2006-01-02 15:04:05.999"
+	DateLayout      = "2006-01-02"
+	// TimeLayout is the Go time layout string for an Athena `time`.
+	TimeLayout = "15:04:05.999999999"
+)
+
+// NormalizeType lower-cases athenaType and strips its parameters, so that
+// e.g. `timestamp(3) with time zone` becomes `timestamp with time zone` and
+// `decimal(38,9)` becomes `decimal`.
+func NormalizeType(athenaType string) string {
+	var (
+		b     strings.Builder
+		depth int
+	)
+	for _, c := range strings.ToLower(athenaType) {
+		switch {
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case depth == 0:
+			b.WriteRune(c)
+		}
+	}
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+// Format returns the text of val the way Athena renders values of
+// athenaType, or nil for a nil val, which is NULL. A *string is returned as
+// is.
+func Format(athenaType string, val interface{}) (*string, error) {
+	switch v := val.(type) {
+	case nil:
+		return nil, nil
+	case *string:
+		return v, nil
+	}
+	text, err := Text(athenaType, val)
+	if err != nil {
+		return nil, err
+	}
+	return &text, nil
+}
+
+// Text returns the text of the non-NULL val the way Athena renders values of
+// athenaType. Values are expected as the driver returns them for athenaType,
+// e.g. []byte for a `varbinary` and time.Duration for an `interval day to
+// second`; other values are formatted like execution parameters.
+func Text(athenaType string, val interface{}) (string, error) {
+	switch v := val.(type) {
+	case string:
+		return v, nil
+	case []byte:
+		if NormalizeType(athenaType) == "varbinary" {
+			return hex.EncodeToString(v), nil
+		}
+		return string(v), nil
+	case time.Time:
+		switch NormalizeType(athenaType) {
+		case "date":
+			return v.Format(DateLayout), nil
+		case "time":
+			return v.Format(precisionLayout(TimeLayout, athenaType)), nil
+		case "time with time zone":
+			return WithTimeZone(precisionLayout(TimeLayout, athenaType), v), nil
+		case "timestamp with time zone":
+			return WithTimeZone(precisionLayout(TimestampLayout, athenaType), v), nil
+		default:
+			return v.Format(precisionLayout(TimestampLayout, athenaType)), nil
+		}
+	case int64:
+		if NormalizeType(athenaType) == "interval year to month" {
+			return YearToMonth(v), nil
+		}
+	case time.Duration:
+		if NormalizeType(athenaType) == "interval day to second" {
+			return DayToSecond(v), nil
+		}
+	case float32:
+		return strconv.FormatFloat(float64(v), 'g', -1, 32), nil
+	case float64:
+		bitSize := 64
+		if t := NormalizeType(athenaType); t == "float" || t == "real" {
+			bitSize = 32
+		}
+		return strconv.FormatFloat(v, 'g', -1, bitSize), nil
+	}
+
+	text, err := cast.ToStringE(val)
+	if err != nil {
+		return "", fmt.Errorf("cannot format %T as %s", val, athenaType)
+	}
+	return text, nil
+}
+
+// precisionLayout returns layout with as many fractional second digits as
+// the precision athenaType declares, e.g. 6 for `timestamp(6)`, or layout
+// itself if it declares none.
+func precisionLayout(layout, athenaType string) string {
+	open, end := strings.IndexByte(athenaType, '('), strings.IndexByte(athenaType, ')')
+	if open < 0 || end < open {
+		return layout
+	}
+	precision, err := strconv.Atoi(strings.TrimSpace(athenaType[open+1 : end]))
+	if err != nil || precision < 0 || precision > 12 {
+		return layout
+	}
+	layout, _, _ = strings.Cut(layout, ".")
+	if precision == 0 {
+		return layout
+	}
+	return layout + "." + strings.Repeat("0", min(precision, 9))
+}
+
+// WithTimeZone formats t with layout followed by its zone: the ID of a named
+// location such as "America/New_York", or else the UTC offset.
+func WithTimeZone(layout string, t time.Time) string {
+	if name := t.Location().String(); name != "" && name != "Local" {
+		return t.Format(layout) + " " + name
+	}
+	return t.Format(layout + " -07:00")
+}
+
+// YearToMonth formats months as an Athena `interval year to month`, e.g.
+// "1-2" for 14 months.
+func YearToMonth(months int64) string {
+	sign := ""
+	if months < 0 {
+		sign, months = "-", -months
+	}
+	return fmt.Sprintf("%s%d-%d", sign, months/12, months%12)
+}
+
+// DayToSecond formats d as an Athena `interval day to second` with
+// millisecond precision, e.g. "2 03:04:05.500".
+func DayToSecond(d time.Duration) string {
+	sign := ""
+	if d < 0 {
+		sign, d = "-", -d
+	}
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%s%d %02d:%02d:%02d.%03d", sign,
+		ms/(24*3600*1000), ms/(3600*1000)%24, ms/(60*1000)%60, ms/1000%60, ms%1000)
+}
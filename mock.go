@@ -1,9 +1,19 @@
 package athena
 
 import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
 	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/athena"
 	"github.com/aws/aws-sdk-go/service/athena/athenaiface"
 	"github.com/stretchr/testify/mock"
@@ -14,19 +24,165 @@ type AthenaAPI interface {
 	athenaiface.AthenaAPI
 }
 
+// Mocker is a testify mock of the AthenaAPI, generated by mockery or written
+// by hand. The Mock functions register expectations returning outputs and
+// errors, never functions computing them.
 type Mocker interface {
 	On(methodName string, arguments ...interface{}) *mock.Call
 }
 
+// MockOption configures the results mocked by MockQuery.
+type MockOption func(*mockConfig)
+
+type mockConfig struct {
+	pageSize       int
+	errorPage      int
+	pageErr        error
+	throttledPages int
+	runningPolls   int
+	runningFor     time.Duration
+}
+
+// MockPageSize splits the mocked results into pages of n rows chained by
+// NextToken. Like with Athena, the header row counts towards the first page.
+func MockPageSize(n int) MockOption {
+	return func(cfg *mockConfig) {
+		cfg.pageSize = n
+	}
+}
+
+// MockPageError makes fetching the page-th page, starting at 1, fail with
+// err, e.g. to test errors in the middle of an iteration.
+func MockPageError(page int, err error) MockOption {
+	return func(cfg *mockConfig) {
+		cfg.errorPage = page
+		cfg.pageErr = err
+	}
+}
+
+// MockThrottledPages makes the first n page fetches fail with a throttling
+// error, which the driver retries.
+func MockThrottledPages(n int) MockOption {
+	return func(cfg *mockConfig) {
+		cfg.throttledPages = n
+	}
+}
+
+// MockRunningPolls keeps the query RUNNING for its first n polls.
+func MockRunningPolls(n int) MockOption {
+	return func(cfg *mockConfig) {
+		cfg.runningPolls = n
+	}
+}
+
+// MockRunningFor keeps the query RUNNING until d elapsed since it started.
+func MockRunningFor(d time.Duration) MockOption {
+	return func(cfg *mockConfig) {
+		cfg.runningFor = d
+	}
+}
+
+// mockExecution mocks starting a query and polling it until it reaches
+// state, and returns the query ID. Stopping the query makes it CANCELLED,
+// check StopQueryExecutionWithContext was called to test it.
+func mockExecution(mocker Mocker, cfg mockConfig, state string, status athena.QueryExecutionStatus) string {
+	queryID := fmt.Sprintf("query-%d", time.Now().UnixNano())
+
+	var (
+		mu      sync.Mutex
+		started time.Time
+		polls   int
+		stopped bool
+	)
+	mocker.On("StartQueryExecution", mock.Anything, mock.Anything).
+		Return(&athena.StartQueryExecutionOutput{QueryExecutionId: aws.String(queryID)}, nil).
+		Run(func(mock.Arguments) {
+			mu.Lock()
+			defer mu.Unlock()
+			started, polls, stopped = time.Now(), 0, false
+		})
+
+	// the state reported by a poll is picked by matching the expectations
+	// below in order against the state of the query, the outputs are static
+	// so that concurrent calls don't share a mutable return value
+	execution := func(status athena.QueryExecutionStatus) *athena.GetQueryExecutionOutput {
+		return &athena.GetQueryExecutionOutput{QueryExecution: &athena.QueryExecution{
+			QueryExecutionId: aws.String(queryID),
+			Status:           &status,
+		}}
+	}
+	mocker.On("GetQueryExecutionWithContext", mock.Anything, mock.MatchedBy(func(*athena.GetQueryExecutionInput) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return stopped
+	})).Return(execution(athena.QueryExecutionStatus{State: aws.String(athena.QueryExecutionStateCancelled)}), nil).Maybe()
+	mocker.On("GetQueryExecutionWithContext", mock.Anything, mock.MatchedBy(func(*athena.GetQueryExecutionInput) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return polls < cfg.runningPolls || time.Since(started) < cfg.runningFor
+	})).Return(execution(athena.QueryExecutionStatus{State: aws.String(athena.QueryExecutionStateRunning)}), nil).
+		Run(func(mock.Arguments) {
+			mu.Lock()
+			defer mu.Unlock()
+			polls++
+		}).Maybe()
+	status.State = aws.String(state)
+	mocker.On("GetQueryExecutionWithContext", mock.Anything, mock.Anything).Return(execution(status), nil)
+
+	mocker.On("StopQueryExecutionWithContext", mock.Anything, mock.Anything).
+		Return(&athena.StopQueryExecutionOutput{}, nil).
+		Run(func(mock.Arguments) {
+			mu.Lock()
+			defer mu.Unlock()
+			stopped = true
+		}).Maybe()
+	return queryID
+}
+
+// MockQueryFailed mocks the AthenaAPI to run queries that fail with reason.
+// errorCategory is the Athena error category: 1 for system errors, 2 for user
+// errors and 3 for other errors. The driver returns a *QueryFailedError.
+func MockQueryFailed(mocker Mocker, reason string, errorCategory int64, opts ...MockOption) {
+	mockExecution(mocker, newMockConfig(opts), athena.QueryExecutionStateFailed, athena.QueryExecutionStatus{
+		StateChangeReason: aws.String(reason),
+		AthenaError: &athena.AthenaError{
+			ErrorCategory: aws.Int64(errorCategory),
+			ErrorMessage:  aws.String(reason),
+		},
+	})
+}
+
+// MockQueryCancelled mocks the AthenaAPI to run queries that get cancelled,
+// e.g. by another client. The driver returns context.Canceled.
+func MockQueryCancelled(mocker Mocker, opts ...MockOption) {
+	mockExecution(mocker, newMockConfig(opts), athena.QueryExecutionStateCancelled, athena.QueryExecutionStatus{})
+}
+
+// MockQueryThrottled mocks the AthenaAPI to reject starting queries with a
+// TooManyRequestsException, as Athena does once the concurrent query limit
+// is reached.
+func MockQueryThrottled(mocker Mocker) {
+	mocker.On("StartQueryExecution", mock.Anything, mock.Anything).Return(nil,
+		awserr.New(athena.ErrCodeTooManyRequestsException, "Rate exceeded", nil))
+}
+
+func newMockConfig(opts []MockOption) mockConfig {
+	var cfg mockConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return cfg
+}
+
 // MockQuery mocks the AthenaAPI to return the given columns and data rows.
 // e.g MockQuery(&mockAPI, map[string]string{"id": "string"}, [][]string{{"1"}})
-func MockQuery(mocker Mocker, columnNames []string, columnTypes []string, mockDataRows [][]string) {
-	queryID := fmt.Sprintf("query-%d", time.Now().UnixNano())
-	state := athena.QueryExecutionStateSucceeded
-	mocker.On("StartQueryExecution", mock.Anything, mock.Anything).Return(&athena.StartQueryExecutionOutput{QueryExecutionId: &queryID}, nil)
-	mocker.On("GetQueryExecutionWithContext", mock.Anything, mock.Anything).Return(&athena.GetQueryExecutionOutput{QueryExecution: &athena.QueryExecution{Status: &athena.QueryExecutionStatus{
-		State: &state,
-	}}}, nil)
+// Every query returns the same result, use FakeAthena to fake several queries.
+//
+// The driver fetches results with GetQueryResultsWithContext, assert calls
+// on that method. GetQueryResults returns the same results but is optional.
+func MockQuery(mocker Mocker, columnNames []string, columnTypes []string, mockDataRows [][]string, opts ...MockOption) {
+	cfg := newMockConfig(opts)
+	mockExecution(mocker, cfg, athena.QueryExecutionStateSucceeded, athena.QueryExecutionStatus{})
 	columnInfos := make([]*athena.ColumnInfo, 0, len(columnNames))
 	for i, colType := range columnTypes {
 		colNameTemp := columnNames[i]
@@ -37,7 +193,12 @@ func MockQuery(mocker Mocker, columnNames []string, columnTypes []string, mockDa
 		})
 	}
 
-	athenaRows := make([]*athena.Row, 1, len(mockDataRows))
+	header := &athena.Row{}
+	for i := range columnNames {
+		header.Data = append(header.Data, &athena.Datum{VarCharValue: &columnNames[i]})
+	}
+	athenaRows := make([]*athena.Row, 1, len(mockDataRows)+1)
+	athenaRows[0] = header
 	for _, rowData := range mockDataRows {
 		datum := make([]*athena.Datum, len(rowData))
 		for i, val := range rowData {
@@ -50,12 +211,396 @@ func MockQuery(mocker Mocker, columnNames []string, columnTypes []string, mockDa
 			Data: datum,
 		})
 	}
-	mocker.On("GetQueryResults", mock.Anything, mock.Anything).Return(&athena.GetQueryResultsOutput{
-		ResultSet: &athena.ResultSet{
-			ResultSetMetadata: &athena.ResultSetMetadata{
-				ColumnInfo: columnInfos,
+	mockResults(mocker, cfg, columnInfos, athenaRows)
+}
+
+// mockResults mocks GetQueryResults to return rows, which start with the
+// header row, in pages.
+func mockResults(mocker Mocker, cfg mockConfig, columnInfos []*athena.ColumnInfo, athenaRows []*athena.Row) {
+	pageSize := cfg.pageSize
+	if pageSize <= 0 {
+		pageSize = len(athenaRows)
+	}
+	// the throttled fetches come first, then each page is served to the
+	// fetches of its NextToken; tokens are the 1-based number of the page
+	if cfg.throttledPages > 0 {
+		throttled := awserr.New(athena.ErrCodeTooManyRequestsException, "Rate exceeded", nil)
+		mocker.On("GetQueryResultsWithContext", mock.Anything, mock.Anything).
+			Return((*athena.GetQueryResultsOutput)(nil), throttled).Times(cfg.throttledPages)
+		mocker.On("GetQueryResults", mock.Anything).
+			Return((*athena.GetQueryResultsOutput)(nil), throttled).Times(cfg.throttledPages).Maybe()
+	}
+	for page, start := 1, 0; page == 1 || start < len(athenaRows); page, start = page+1, start+pageSize {
+		end := min(start+pageSize, len(athenaRows))
+		out := &athena.GetQueryResultsOutput{
+			ResultSet: &athena.ResultSet{
+				ResultSetMetadata: &athena.ResultSetMetadata{
+					ColumnInfo: columnInfos,
+				},
+				Rows: athenaRows[start:end],
 			},
-			Rows: athenaRows,
-		},
-	}, nil)
+		}
+		if end < len(athenaRows) {
+			out.NextToken = aws.String(strconv.Itoa(page + 1))
+		}
+		var err error
+		if page == cfg.errorPage {
+			out, err = nil, cfg.pageErr
+		}
+
+		var token *string
+		if page > 1 {
+			token = aws.String(strconv.Itoa(page))
+		}
+		atToken := mock.MatchedBy(func(input *athena.GetQueryResultsInput) bool {
+			return aws.StringValue(input.NextToken) == aws.StringValue(token)
+		})
+		withContext := mocker.On("GetQueryResultsWithContext", mock.Anything, atToken).Return(out, err)
+		// the driver only calls GetQueryResultsWithContext, GetQueryResults
+		// is mocked too for code calling the client directly
+		mocker.On("GetQueryResults", atToken).Return(out, err).Maybe()
+		if page > 1 {
+			// the rows may be closed before the following pages are fetched
+			withContext.Maybe()
+		}
+	}
+	invalidToken := errors.New("athena: mock: no result page at NextToken")
+	mocker.On("GetQueryResultsWithContext", mock.Anything, mock.Anything).
+		Return((*athena.GetQueryResultsOutput)(nil), invalidToken).Maybe()
+	mocker.On("GetQueryResults", mock.Anything).
+		Return((*athena.GetQueryResultsOutput)(nil), invalidToken).Maybe()
+}
+
+// MockQueryFromStructs mocks the AthenaAPI to return rows, one row per
+// element. The columns are the fields of T, named the way QueryStructs maps
+// them: by their athena tag or snake_cased name, with the fields of embedded
+// structs inlined.
+//
+// Column types are inferred from the Go types: booleans, integers, floats and
+// strings map to the matching Athena types, time.Time to timestamp,
+// AthenaDate to date, Decimal to decimal, []byte to varbinary, slices to
+// arrays, maps to maps and structs to rows. sql.Null* types and pointers are
+// nullable. Set the athenatype tag to override the type, e.g. to
+// `athenatype:"date"` for a time.Time. Values are formatted as Athena renders
+// them. It panics if a field has a type without Athena equivalent.
+func MockQueryFromStructs[T any](mocker Mocker, rows []T, opts ...MockOption) {
+	t := reflect.TypeOf((*T)(nil)).Elem()
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		panic(fmt.Sprintf("athena: MockQueryFromStructs needs a struct type, got %s", t))
+	}
+	columns, err := mockColumns(t)
+	if err != nil {
+		panic(fmt.Sprintf("athena: MockQueryFromStructs: %v", err))
+	}
+
+	columnInfos := make([]*athena.ColumnInfo, len(columns))
+	header := &athena.Row{}
+	for i, column := range columns {
+		columnInfos[i] = &athena.ColumnInfo{
+			Name:     aws.String(column.name),
+			Label:    aws.String(column.name),
+			Type:     aws.String(column.athenaType),
+			Nullable: aws.String(athena.ColumnNullableUnknown),
+		}
+		header.Data = append(header.Data, &athena.Datum{VarCharValue: aws.String(column.name)})
+	}
+	athenaRows := []*athena.Row{header}
+	for _, row := range rows {
+		athenaRow := &athena.Row{}
+		for i, column := range columns {
+			// nil elements are rows of NULLs
+			text, err := formatMockValue(column.athenaType, mockFieldValue(reflect.ValueOf(row), column.index))
+			if err != nil {
+				panic(fmt.Sprintf("athena: MockQueryFromStructs: column %s: %v", column.name, err))
+			}
+			athenaRow.Data = append(athenaRow.Data, &athena.Datum{VarCharValue: text})
+			if text != nil && isDecimalType(column.athenaType) {
+				setDecimalScale(columnInfos[i], *text)
+			}
+		}
+		athenaRows = append(athenaRows, athenaRow)
+	}
+
+	cfg := newMockConfig(opts)
+	mockExecution(mocker, cfg, athena.QueryExecutionStateSucceeded, athena.QueryExecutionStatus{})
+	mockResults(mocker, cfg, columnInfos, athenaRows)
+}
+
+// mockColumn is a column of the results of MockQueryFromStructs.
+type mockColumn struct {
+	name       string
+	athenaType string
+	index      []int
+}
+
+// mockColumns returns the columns of the struct type t in field order.
+func mockColumns(t reflect.Type) ([]mockColumn, error) {
+	fields := structFields(t)
+	var (
+		columns []mockColumn
+		err     error
+	)
+	visitStructFields(t, nil, func(name string, f reflect.StructField, index []int) {
+		// skip fields hidden by fields of the outer struct
+		if err != nil || !slices.Equal(fields[name], index) {
+			return
+		}
+		athenaType := f.Tag.Get("athenatype")
+		if athenaType == "" {
+			if athenaType, err = mockType(f.Type); err != nil {
+				err = fmt.Errorf("field %s: %w", f.Name, err)
+				return
+			}
+		}
+		columns = append(columns, mockColumn{name: name, athenaType: athenaType, index: index})
+	})
+	return columns, err
+}
+
+var (
+	typeAthenaDate  = reflect.TypeOf(AthenaDate{})
+	typeDecimal     = reflect.TypeOf(Decimal{})
+	typeNullDecimal = reflect.TypeOf(NullDecimal{})
+	typeBytes       = reflect.TypeOf([]byte(nil))
+
+	// nullTypes are the Athena types of the sql.Null* types.
+	nullTypes = map[reflect.Type]string{
+		reflect.TypeOf(sql.NullString{}):  "varchar",
+		reflect.TypeOf(sql.NullBool{}):    "boolean",
+		reflect.TypeOf(sql.NullByte{}):    "tinyint",
+		reflect.TypeOf(sql.NullInt16{}):   "smallint",
+		reflect.TypeOf(sql.NullInt32{}):   "integer",
+		reflect.TypeOf(sql.NullInt64{}):   "bigint",
+		reflect.TypeOf(sql.NullFloat64{}): "double",
+		reflect.TypeOf(sql.NullTime{}):    "timestamp",
+	}
+)
+
+// mockType returns the Athena type of values of the Go type t.
+func mockType(t reflect.Type) (string, error) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if athenaType, ok := nullTypes[t]; ok {
+		return athenaType, nil
+	}
+	switch t {
+	case typeTime:
+		return "timestamp", nil
+	case typeAthenaDate:
+		return "date", nil
+	case typeDecimal, typeNullDecimal:
+		return "decimal", nil
+	case typeBytes:
+		return "varbinary", nil
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return "boolean", nil
+	case reflect.Int8:
+		return "tinyint", nil
+	case reflect.Int16, reflect.Uint8:
+		return "smallint", nil
+	case reflect.Int32, reflect.Uint16:
+		return "integer", nil
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint32, reflect.Uint64:
+		return "bigint", nil
+	case reflect.Float32:
+		return "real", nil
+	case reflect.Float64:
+		return "double", nil
+	case reflect.String:
+		return "varchar", nil
+	case reflect.Slice, reflect.Array:
+		elem, err := mockType(t.Elem())
+		if err != nil {
+			return "", err
+		}
+		return "array(" + elem + ")", nil
+	case reflect.Map:
+		key, err := mockType(t.Key())
+		if err != nil {
+			return "", err
+		}
+		elem, err := mockType(t.Elem())
+		if err != nil {
+			return "", err
+		}
+		return "map(" + key + ", " + elem + ")", nil
+	case reflect.Struct:
+		columns, err := mockColumns(t)
+		if err != nil {
+			return "", err
+		}
+		fields := make([]string, len(columns))
+		for i, column := range columns {
+			fields[i] = column.name + " " + column.athenaType
+		}
+		return "row(" + strings.Join(fields, ", ") + ")", nil
+	}
+	return "", fmt.Errorf("no Athena type for %s", t)
+}
+
+// mockFieldValue returns the field at index of the struct v, or an invalid
+// value if v is invalid or a pointer to an embedded struct on the way is nil.
+func mockFieldValue(v reflect.Value, index []int) reflect.Value {
+	for _, x := range index {
+		for v.Kind() == reflect.Pointer && !v.IsNil() {
+			v = v.Elem()
+		}
+		if v.Kind() != reflect.Struct {
+			return reflect.Value{}
+		}
+		v = v.Field(x)
+	}
+	return v
+}
+
+// formatMockValue renders v the way Athena renders values of athenaType, or
+// returns nil for NULL.
+func formatMockValue(athenaType string, v reflect.Value) (*string, error) {
+	for v.IsValid() && (v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface) {
+		if v.IsNil() {
+			return nil, nil
+		}
+		v = v.Elem()
+	}
+	if !v.IsValid() {
+		return nil, nil
+	}
+
+	switch val := v.Interface().(type) {
+	case AthenaDate:
+		return formatValue(athenaType, time.Time(val))
+	case NullDecimal:
+		if !val.Valid {
+			return nil, nil
+		}
+		return formatValue(athenaType, val.Decimal)
+	case driver.Valuer:
+		if _, ok := nullTypes[v.Type()]; ok {
+			dv, err := val.Value()
+			if err != nil || dv == nil {
+				return nil, err
+			}
+			return formatValue(athenaType, dv)
+		}
+	}
+
+	params := mockTypeParams(athenaType)
+	paramType := func(i int, t reflect.Type) (string, error) {
+		if i < len(params) {
+			return params[i], nil
+		}
+		return mockType(t)
+	}
+	var parts []string
+	switch {
+	case (v.Kind() == reflect.Slice || v.Kind() == reflect.Array) && v.Type() != typeBytes:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return nil, nil
+		}
+		elemType, err := paramType(0, v.Type().Elem())
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i < v.Len(); i++ {
+			elem, err := formatMockValue(elemType, v.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			parts = append(parts, mockText(elem))
+		}
+		return aws.String("[" + strings.Join(parts, ", ") + "]"), nil
+	case v.Kind() == reflect.Map:
+		if v.IsNil() {
+			return nil, nil
+		}
+		keyType, err := paramType(0, v.Type().Key())
+		if err != nil {
+			return nil, err
+		}
+		elemType, err := paramType(1, v.Type().Elem())
+		if err != nil {
+			return nil, err
+		}
+		for iter := v.MapRange(); iter.Next(); {
+			key, err := formatMockValue(keyType, iter.Key())
+			if err != nil {
+				return nil, err
+			}
+			elem, err := formatMockValue(elemType, iter.Value())
+			if err != nil {
+				return nil, err
+			}
+			parts = append(parts, mockText(key)+"="+mockText(elem))
+		}
+		// map iteration order is random, sort the entries for stable results
+		slices.Sort(parts)
+		return aws.String("{" + strings.Join(parts, ", ") + "}"), nil
+	case isRowField(v.Type()) && v.Type() != typeDecimal:
+		columns, err := mockColumns(v.Type())
+		if err != nil {
+			return nil, err
+		}
+		for i, column := range columns {
+			fieldType := column.athenaType
+			if i < len(params) {
+				fieldType = params[i]
+			}
+			field, err := formatMockValue(fieldType, mockFieldValue(v, column.index))
+			if err != nil {
+				return nil, err
+			}
+			parts = append(parts, column.name+"="+mockText(field))
+		}
+		return aws.String("{" + strings.Join(parts, ", ") + "}"), nil
+	}
+	return formatValue(athenaType, v.Interface())
+}
+
+// mockTypeParams returns the element type of an array, the key and value
+// types of a map or the field types of a row type.
+func mockTypeParams(athenaType string) []string {
+	name := normalizeType(athenaType)
+	open, end := strings.IndexByte(athenaType, '('), strings.LastIndexByte(athenaType, ')')
+	if (name != "array" && name != "map" && name != "row") || open < 0 || end < open {
+		return nil
+	}
+	params := splitTopLevel(athenaType[open+1:end], ",")
+	for i, param := range params {
+		param = strings.TrimSpace(param)
+		if name == "row" {
+			if _, typ, ok := strings.Cut(param, " "); ok {
+				param = typ
+			}
+		}
+		params[i] = param
+	}
+	return params
+}
+
+// mockText renders NULL elements of arrays, maps and rows.
+func mockText(text *string) string {
+	if text == nil {
+		return "null"
+	}
+	return *text
+}
+
+// setDecimalScale widens the scale of the decimal column info to that of the
+// value text.
+func setDecimalScale(info *athena.ColumnInfo, text string) {
+	scale := int64(0)
+	if _, frac, ok := strings.Cut(text, "."); ok {
+		scale = int64(len(frac))
+	}
+	if info.Scale == nil || *info.Scale < scale {
+		info.Precision = aws.Int64(38)
+		info.Scale = aws.Int64(scale)
+	}
 }
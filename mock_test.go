@@ -1,10 +1,21 @@
 package athena
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/aws/aws-sdk-go/service/athena/athenaiface"
 	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 	"github.com/vx416/go-athenav/athenamock"
 )
@@ -12,6 +23,7 @@ import (
 func TestMockQuery(t *testing.T) {
 	mockAPI := athenamock.AthenaAPI{}
 	EnableMockMode(&mockAPI)
+	defer DisableMockMode()
 	db, err := sql.Open("athena", "mock")
 	require.NoError(t, err, "Open failed")
 
@@ -32,6 +44,12 @@ func TestMockQuery(t *testing.T) {
 		scanOK = true
 	}
 	require.True(t, scanOK, "No rows")
+	mockAPI.AssertExpectations(t)
+
+	// code calling the client directly still gets the results
+	out, err := mockAPI.GetQueryResults(&athena.GetQueryResultsInput{QueryExecutionId: aws.String("query")})
+	require.NoError(t, err)
+	assert.Len(t, out.ResultSet.Rows, 2)
 
 	mockAPI.ExpectedCalls = nil
 	mockAPI.Calls = nil
@@ -49,6 +67,331 @@ func TestMockQuery(t *testing.T) {
 
 }
 
+func TestMockQuery_Pages(t *testing.T) {
+	data := [][]string{{"1"}, {"2"}, {"3"}, {"4"}, {"5"}}
+	scanAll := func(db *sql.DB) ([]int, error) {
+		rows, err := db.Query("SELECT id FROM test_table")
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		var ids []int
+		for rows.Next() {
+			var id int
+			if err := rows.Scan(&id); err != nil {
+				return ids, err
+			}
+			ids = append(ids, id)
+		}
+		return ids, rows.Err()
+	}
+
+	mockAPI := athenamock.AthenaAPI{}
+	MockQuery(&mockAPI, []string{"id"}, []string{"integer"}, data, MockPageSize(2))
+	ids, err := scanAll(openMockDB(t, &mockAPI))
+	require.NoError(t, err)
+	require.Equal(t, []int{1, 2, 3, 4, 5}, ids)
+	mockAPI.AssertNumberOfCalls(t, "GetQueryResultsWithContext", 3)
+
+	pageErr := errors.New("page unavailable")
+	mockAPI = athenamock.AthenaAPI{}
+	MockQuery(&mockAPI, []string{"id"}, []string{"integer"}, data, MockPageSize(2), MockPageError(2, pageErr))
+	ids, err = scanAll(openMockDB(t, &mockAPI))
+	require.ErrorIs(t, err, pageErr)
+	require.Equal(t, []int{1}, ids)
+}
+
+func TestMockQuery_Throttled(t *testing.T) {
+	mockAPI := athenamock.AthenaAPI{}
+	MockQuery(&mockAPI, []string{"id"}, []string{"integer"}, [][]string{{"1"}}, MockThrottledPages(1))
+	var id int
+	require.NoError(t, openMockDB(t, &mockAPI).QueryRow("SELECT id FROM test_table").Scan(&id))
+	require.Equal(t, 1, id)
+	mockAPI.AssertNumberOfCalls(t, "GetQueryResultsWithContext", 2)
+
+	mockAPI = athenamock.AthenaAPI{}
+	MockQueryThrottled(&mockAPI)
+	_, err := openMockDB(t, &mockAPI).Query("SELECT id FROM test_table")
+	var aerr awserr.Error
+	require.ErrorAs(t, err, &aerr)
+	require.Equal(t, athena.ErrCodeTooManyRequestsException, aerr.Code())
+}
+
+func TestMockQueryFailed(t *testing.T) {
+	mockAPI := athenamock.AthenaAPI{}
+	MockQueryFailed(&mockAPI, "SYNTAX_ERROR: line 1:8: Column 'x' cannot be resolved", 2, MockRunningPolls(2))
+	_, err := openMockDB(t, &mockAPI).Query("SELECT x FROM test_table")
+	var failed *QueryFailedError
+	require.ErrorAs(t, err, &failed)
+	require.Equal(t, "SYNTAX_ERROR: line 1:8: Column 'x' cannot be resolved", failed.Reason)
+	require.Equal(t, int64(2), failed.ErrorCategory)
+	mockAPI.AssertNumberOfCalls(t, "GetQueryExecutionWithContext", 3)
+	mockAPI.AssertNotCalled(t, "StopQueryExecutionWithContext", mock.Anything, mock.Anything)
+}
+
+func TestMockQueryCancelled(t *testing.T) {
+	mockAPI := athenamock.AthenaAPI{}
+	MockQueryCancelled(&mockAPI)
+	_, err := openMockDB(t, &mockAPI).Query("SELECT id FROM test_table")
+	require.ErrorIs(t, err, context.Canceled)
+}
+
+func TestMockQuery_Slow(t *testing.T) {
+	mockAPI := athenamock.AthenaAPI{}
+	MockQuery(&mockAPI, []string{"id"}, []string{"integer"}, [][]string{{"1"}}, MockRunningFor(time.Minute))
+	db := openMockDB(t, &mockAPI)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err := db.QueryContext(ctx, "SELECT id FROM test_table")
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	mockAPI.AssertCalled(t, "StopQueryExecutionWithContext", mock.Anything, mock.Anything)
+	mockAPI.AssertNotCalled(t, "GetQueryResultsWithContext", mock.Anything, mock.Anything)
+}
+
+// plainAthenaAPI is a hand-written testify mock, which unlike the mockery
+// generated one takes the return values as they are.
+type plainAthenaAPI struct {
+	athenaiface.AthenaAPI
+	mock.Mock
+}
+
+func (m *plainAthenaAPI) StartQueryExecution(input *athena.StartQueryExecutionInput) (*athena.StartQueryExecutionOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*athena.StartQueryExecutionOutput), args.Error(1)
+}
+
+func (m *plainAthenaAPI) GetQueryExecutionWithContext(ctx aws.Context, input *athena.GetQueryExecutionInput, _ ...request.Option) (*athena.GetQueryExecutionOutput, error) {
+	args := m.Called(ctx, input)
+	return args.Get(0).(*athena.GetQueryExecutionOutput), args.Error(1)
+}
+
+func (m *plainAthenaAPI) GetQueryResultsWithContext(ctx aws.Context, input *athena.GetQueryResultsInput, _ ...request.Option) (*athena.GetQueryResultsOutput, error) {
+	args := m.Called(ctx, input)
+	return args.Get(0).(*athena.GetQueryResultsOutput), args.Error(1)
+}
+
+func TestMockQuery_PlainMock(t *testing.T) {
+	mockAPI := &plainAthenaAPI{}
+	MockQuery(mockAPI, []string{"id"}, []string{"integer"}, [][]string{{"1"}, {"2"}, {"3"}}, MockPageSize(2), MockRunningPolls(1))
+
+	rows, err := openMockDB(t, mockAPI).Query("SELECT id FROM test_table")
+	require.NoError(t, err)
+	defer rows.Close()
+	var ids []int
+	for rows.Next() {
+		var id int
+		require.NoError(t, rows.Scan(&id))
+		ids = append(ids, id)
+	}
+	require.NoError(t, rows.Err())
+	assert.Equal(t, []int{1, 2, 3}, ids)
+	mockAPI.AssertNumberOfCalls(t, "GetQueryExecutionWithContext", 2)
+	mockAPI.AssertNumberOfCalls(t, "GetQueryResultsWithContext", 2)
+}
+
+func TestMockQuery_Concurrent(t *testing.T) {
+	data := [][]string{{"1"}, {"2"}, {"3"}, {"4"}, {"5"}}
+	mockAPI := athenamock.AthenaAPI{}
+	MockQuery(&mockAPI, []string{"id"}, []string{"integer"}, data, MockPageSize(1), MockRunningPolls(1))
+	db := openMockDB(t, &mockAPI)
+
+	var wg sync.WaitGroup
+	sums := make([]int, 64)
+	errs := make([]error, len(sums))
+	for i := range sums {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			rows, err := db.Query("SELECT id FROM test_table")
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			defer rows.Close()
+			for rows.Next() {
+				var id int
+				if errs[i] = rows.Scan(&id); errs[i] != nil {
+					return
+				}
+				sums[i] += id
+			}
+			errs[i] = rows.Err()
+		}(i)
+	}
+	wg.Wait()
+	for i := range sums {
+		require.NoError(t, errs[i])
+		assert.Equal(t, 15, sums[i])
+	}
+}
+
+func TestMockQueryFromStructs(t *testing.T) {
+	score, zip := 1.5, 75001
+	amount, err := ParseDecimal("10.25")
+	require.NoError(t, err)
+	users := []scanUser{
+		{
+			scanBase:  scanBase{ID: 1},
+			UserName:  "vic",
+			Score:     &score,
+			Amount:    amount,
+			CreatedAt: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+			Address:   scanAddress{City: "Paris", Zip: &zip},
+		},
+		{scanBase: scanBase{ID: 2}, UserName: "bob", Address: scanAddress{City: "a, b"}},
+	}
+
+	mockAPI := athenamock.AthenaAPI{}
+	MockQueryFromStructs(&mockAPI, users)
+	got, err := QueryStructs[scanUser](WithStrictScan(context.Background()), openMockDB(t, &mockAPI), "SELECT * FROM users")
+	require.NoError(t, err)
+	require.Len(t, got, 2)
+	assert.Equal(t, users[0].ID, got[0].ID)
+	assert.Equal(t, users[0].Score, got[0].Score)
+	assert.Equal(t, "10.25", got[0].Amount.String())
+	assert.Equal(t, users[0].CreatedAt, got[0].CreatedAt)
+	assert.Equal(t, users[0].Address, got[0].Address)
+	assert.Nil(t, got[1].Score)
+	assert.Equal(t, "0", got[1].Amount.String())
+	assert.Equal(t, users[1].Address, got[1].Address)
+}
+
+func TestMockQueryFromStructs_Types(t *testing.T) {
+	type event struct {
+		Day     time.Time `athenatype:"date"`
+		Date    AthenaDate
+		Active  bool
+		Count   sql.NullInt64
+		Raw     []byte
+		Tags    []string
+		Attrs   map[string]*int
+		Matrix  [][]float64
+		Created *time.Time
+	}
+	one := 1
+	day := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	mockAPI := athenamock.AthenaAPI{}
+	MockQueryFromStructs(&mockAPI, []*event{{
+		Day:    day,
+		Date:   AthenaDate(day),
+		Active: true,
+		Count:  sql.NullInt64{Int64: 3, Valid: true},
+		Raw:    []byte("hi"),
+		Tags:   []string{"a", "b"},
+		Attrs:  map[string]*int{"y": nil, "x": &one},
+		Matrix: [][]float64{{1.5}, {}},
+	}, nil})
+
+	out, err := mockAPI.GetQueryResultsWithContext(context.Background(), &athena.GetQueryResultsInput{})
+	require.NoError(t, err)
+	var types []string
+	for _, column := range out.ResultSet.ResultSetMetadata.ColumnInfo {
+		types = append(types, *column.Name+" "+*column.Type)
+	}
+	assert.Equal(t, []string{
+		"day date", "date date", "active boolean", "count bigint", "raw varbinary",
+		"tags array(varchar)", "attrs map(varchar, bigint)", "matrix array(array(double))", "created timestamp",
+	}, types)
+
+	var values []*string
+	for _, datum := range out.ResultSet.Rows[1].Data {
+		values = append(values, datum.VarCharValue)
+	}
+	s := aws.String
+	assert.Equal(t, []*string{
+		s("2024-01-02"), s("2024-01-02"), s("true"), s("3"), s("6869"),
+		s("[a, b]"), s("{x=1, y=null}"), s("[[1.5], []]"), nil,
+	}, values)
+	for _, datum := range out.ResultSet.Rows[2].Data {
+		assert.Nil(t, datum.VarCharValue)
+	}
+
+	require.PanicsWithValue(t, "athena: MockQueryFromStructs: field Ch: no Athena type for chan int", func() {
+		MockQueryFromStructs(&athenamock.AthenaAPI{}, []struct{ Ch chan int }{})
+	})
+}
+
+func TestMockQueryFromStructs_AthenaDate(t *testing.T) {
+	type event struct {
+		ID   int
+		Date AthenaDate
+		Next *AthenaDate
+	}
+	day := AthenaDate(time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC))
+	events := []event{{ID: 1, Date: day, Next: &day}, {ID: 2, Date: day}}
+
+	mockAPI := athenamock.AthenaAPI{}
+	MockQueryFromStructs(&mockAPI, events)
+	got, err := QueryStructs[event](WithStrictScan(context.Background()), openMockDB(t, &mockAPI), "SELECT * FROM events")
+	require.NoError(t, err)
+	require.Len(t, got, 2)
+	assert.Equal(t, "2024-01-02", got[0].Date.String())
+	require.NotNil(t, got[0].Next)
+	assert.True(t, day.Equal(*got[0].Next))
+	assert.Equal(t, "2024-01-02", got[1].Date.String())
+	assert.Nil(t, got[1].Next)
+}
+
+func TestMockQueryFromStructs_TimeZones(t *testing.T) {
+	type event struct {
+		At    time.Time `athenatype:"timestamp(3) with time zone"`
+		Local time.Time `athenatype:"timestamp with time zone"`
+		Clock time.Time `athenatype:"time with time zone"`
+	}
+	newYork, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+	offset := time.FixedZone("", 5*3600+30*60)
+	events := []event{{
+		At:    time.Date(2024, 1, 2, 3, 4, 5, 123000000, offset),
+		Local: time.Date(2024, 1, 2, 3, 4, 5, 0, newYork),
+		Clock: time.Date(0, 1, 1, 3, 4, 5, 0, time.FixedZone("", -8*3600)),
+	}}
+
+	mockAPI := athenamock.AthenaAPI{}
+	MockQueryFromStructs(&mockAPI, events)
+	out, err := mockAPI.GetQueryResultsWithContext(context.Background(), &athena.GetQueryResultsInput{})
+	require.NoError(t, err)
+	var values []string
+	for _, datum := range out.ResultSet.Rows[1].Data {
+		values = append(values, *datum.VarCharValue)
+	}
+	assert.Equal(t, []string{"2024-01-02 03:04:05.123 +05:30", "2024-01-02 03:04:05 America/New_York", "03:04:05 -08:00"}, values)
+
+	got, err := QueryStructs[event](context.Background(), openMockDB(t, &mockAPI), "SELECT * FROM events")
+	require.NoError(t, err)
+	require.Len(t, got, 1)
+	assert.True(t, events[0].At.Equal(got[0].At), got[0].At)
+	_, gotOffset := got[0].At.Zone()
+	assert.Equal(t, 5*3600+30*60, gotOffset)
+	assert.True(t, events[0].Local.Equal(got[0].Local), got[0].Local)
+	assert.Equal(t, "America/New_York", got[0].Local.Location().String())
+	assert.Equal(t, "03:04:05 -08:00", got[0].Clock.Format("15:04:05 -07:00"))
+}
+
+func TestDisableMockMode(t *testing.T) {
+	EnableMockMode(NewFakeAthena())
+	_, err := sql.Open("athena", "mock")
+	require.NoError(t, err)
+
+	DisableMockMode()
+	_, err = sql.Open("athena", "mock")
+	require.Error(t, err, "mock mode still enabled")
+}
+
+func TestOpen_WithAthenaClient(t *testing.T) {
+	fake := NewFakeAthena()
+	fake.Register("SELECT 1", FakeResult{Columns: []FakeColumn{{Name: "one", Type: "integer"}}, Rows: [][]interface{}{{1}}})
+	db, err := Open(Config{Database: "db", OutputLocation: "s3://results", Athena: fake})
+	require.NoError(t, err)
+	defer db.Close()
+
+	var one int
+	require.NoError(t, db.QueryRow("SELECT 1").Scan(&one))
+	require.Equal(t, 1, one)
+	require.Equal(t, "db", fake.Queries()[0].Database)
+}
+
 type user struct {
 	ID   int    `db:"id"`
 	Name string `db:"name"`
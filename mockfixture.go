@@ -0,0 +1,86 @@
+package athena
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// mockDSNScheme prefixes DSNs naming a fixture file.
+	mockDSNScheme = "mock://"
+	// mockPollFrequency is how often queries served from fixtures are polled.
+	mockPollFrequency = 10 * time.Millisecond
+)
+
+// mockFixtures is the content of a fixture file.
+type mockFixtures struct {
+	Queries []mockFixture `yaml:"queries"`
+}
+
+// mockFixture is the result of the queries whose text is Query, or which
+// match Regexp.
+type mockFixture struct {
+	Query     string          `yaml:"query"`
+	Regexp    string          `yaml:"regexp"`
+	Columns   []FakeColumn    `yaml:"columns"`
+	Rows      [][]interface{} `yaml:"rows"`
+	Error     string          `yaml:"error"`
+	Cancelled bool            `yaml:"cancelled"`
+	Delay     time.Duration   `yaml:"delay"`
+}
+
+// loadMockFixtures returns a FakeAthena serving the fixtures in the YAML
+// file at path.
+func loadMockFixtures(path string) (*FakeAthena, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read mock fixtures: %w", err)
+	}
+	var fixtures mockFixtures
+	if err := yaml.Unmarshal(b, &fixtures); err != nil {
+		return nil, fmt.Errorf("parse mock fixtures %s: %w", path, err)
+	}
+
+	fake := NewFakeAthena()
+	for i, fixture := range fixtures.Queries {
+		if err := fixture.register(fake); err != nil {
+			return nil, fmt.Errorf("mock fixtures %s: query %d: %w", path, i+1, err)
+		}
+	}
+	return fake, nil
+}
+
+func (f *mockFixture) register(fake *FakeAthena) error {
+	for i, row := range f.Rows {
+		if len(row) != len(f.Columns) {
+			return fmt.Errorf("row %d has %d values for %d columns", i+1, len(row), len(f.Columns))
+		}
+	}
+	result := FakeResult{
+		Columns:       f.Columns,
+		Rows:          f.Rows,
+		FailureReason: f.Error,
+		Cancelled:     f.Cancelled,
+		Delay:         f.Delay,
+	}
+
+	switch {
+	case f.Query != "" && f.Regexp != "":
+		return errors.New("only one of query and regexp can be set")
+	case f.Query != "":
+		fake.Register(f.Query, result)
+	case f.Regexp != "":
+		if _, err := regexp.Compile(f.Regexp); err != nil {
+			return err
+		}
+		fake.RegisterRegexp(f.Regexp, result)
+	default:
+		return errors.New("query or regexp is required")
+	}
+	return nil
+}
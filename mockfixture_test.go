@@ -0,0 +1,78 @@
+package athena
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMockDSN(t *testing.T) {
+	for _, dsn := range []string{
+		"mock://testdata/mock_fixtures.yaml",
+		"mock://testdata/mock_fixtures.yaml?page_size=1",
+		"mock=testdata/mock_fixtures.yaml&db=analytics",
+	} {
+		db, err := sql.Open("athena", dsn)
+		require.NoError(t, err, dsn)
+
+		rows, err := db.Query("SELECT id, name, joined FROM users WHERE id > ?", 0)
+		require.NoError(t, err, dsn)
+		var (
+			ids    []int
+			names  []*string
+			joined []*time.Time
+		)
+		for rows.Next() {
+			var id int
+			var name *string
+			var day *time.Time
+			require.NoError(t, rows.Scan(&id, &name, &day))
+			ids = append(ids, id)
+			names = append(names, name)
+			joined = append(joined, day)
+		}
+		require.NoError(t, rows.Err(), dsn)
+		assert.Equal(t, []int{1, 2}, ids, dsn)
+		assert.Equal(t, "vic", *names[0], dsn)
+		assert.Nil(t, names[1], dsn)
+		assert.Equal(t, time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC), *joined[0], dsn)
+		assert.Nil(t, joined[1], dsn)
+
+		start := time.Now()
+		var one int
+		require.NoError(t, db.QueryRow("SELECT 1").Scan(&one), dsn)
+		assert.Equal(t, 1, one)
+		assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond, dsn)
+
+		_, err = db.Exec("DROP TABLE users")
+		assert.EqualError(t, err, "AccessDeniedException: not allowed", dsn)
+		_, err = db.Query("SELECT 2")
+		assert.ErrorContains(t, err, "no fake result registered for query: SELECT 2", dsn)
+		require.NoError(t, db.Close())
+	}
+}
+
+func TestLoadMockFixtures_Invalid(t *testing.T) {
+	dir := t.TempDir()
+	tests := map[string]string{
+		"queries:\n  - columns: [{name: a, type: integer}]\n":                     "query 1: query or regexp is required",
+		"queries:\n  - {query: SELECT 1, regexp: ^SELECT}\n":                      "query 1: only one of query and regexp can be set",
+		"queries:\n  - {regexp: \"(\"}\n":                                         "query 1: error parsing regexp",
+		"queries:\n  - {query: SELECT 1, columns: [{name: a}], rows: [[1, 2]]}\n": "query 1: row 1 has 2 values for 1 columns",
+		"queries: {}\n": "parse mock fixtures",
+	}
+	for content, expected := range tests {
+		path := filepath.Join(dir, "fixtures.yaml")
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+		_, err := sql.Open("athena", "mock="+path)
+		assert.ErrorContains(t, err, expected, content)
+	}
+
+	_, err := sql.Open("athena", "mock://testdata/missing.yaml")
+	assert.ErrorContains(t, err, "read mock fixtures")
+}
@@ -0,0 +1,208 @@
+package athena
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/athena"
+)
+
+// Page is a page of query results returned by FetchPage.
+type Page struct {
+	Columns []*athena.ColumnInfo
+	// Rows holds the values of each row, typed like rows scanned through
+	// database/sql.
+	Rows [][]interface{}
+	// Cursor resumes after the last row of the page. It's empty once the
+	// results are exhausted.
+	Cursor string
+}
+
+// pageCursor is the position of the next row of a query result.
+type pageCursor struct {
+	QueryID string `json:"q"`
+	// NextToken is the token of the GetQueryResults page holding the next
+	// row, empty for the first page.
+	NextToken string `json:"t,omitempty"`
+	// Offset is the number of rows of that page already returned, including
+	// the header row.
+	Offset int64 `json:"o,omitempty"`
+	// PageSize is the MaxResults the page was fetched with, so it can be
+	// fetched again with the same rows.
+	PageSize int64 `json:"s,omitempty"`
+}
+
+func (c pageCursor) encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodePageCursor(s string) (pageCursor, error) {
+	var c pageCursor
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, errors.New("invalid page cursor")
+	}
+	if err := json.Unmarshal(b, &c); err != nil || c.QueryID == "" {
+		return c, errors.New("invalid page cursor")
+	}
+	return c, nil
+}
+
+// FetchPage returns up to size rows of the result of the finished query
+// queryID, starting at cursor, or at the first row if cursor is empty.
+// Together with the cursor of the returned page, it lets stateless servers
+// page through results across requests.
+//
+// db must have been opened with this driver. size must be between 1 and 1000.
+func FetchPage(ctx context.Context, db *sql.DB, queryID, cursor string, size int64) (*Page, error) {
+	if err := validatePageSize(size); err != nil {
+		return nil, err
+	}
+
+	pos := pageCursor{QueryID: queryID}
+	if cursor != "" {
+		var err error
+		if pos, err = decodePageCursor(cursor); err != nil {
+			return nil, err
+		}
+		if pos.QueryID != queryID {
+			return nil, fmt.Errorf("page cursor is for query %s, not %s", pos.QueryID, queryID)
+		}
+	}
+
+	var page *Page
+	err := withConn(ctx, db, func(c *conn) error {
+		var err error
+		page, err = c.fetchPage(ctx, pos, size)
+		return err
+	})
+	return page, err
+}
+
+// RunQuery runs query on db, waits for it to finish and returns its query
+// execution ID, whose results can then be paged through with FetchPage.
+func RunQuery(ctx context.Context, db *sql.DB, query string, args ...interface{}) (string, error) {
+	namedArgs := make([]driver.NamedValue, 0, len(args))
+	for i, arg := range args {
+		namedArgs = append(namedArgs, driver.NamedValue{Ordinal: i + 1, Value: arg})
+	}
+
+	var queryID string
+	err := withConn(ctx, db, func(c *conn) error {
+		var err error
+		if queryID, err = c.startQuery(query, namedArgs); err != nil {
+			return err
+		}
+		_, err = c.waitOnQuery(ctx, queryID)
+		return err
+	})
+	return queryID, err
+}
+
+// withConn calls fn with a driver connection of db.
+func withConn(ctx context.Context, db *sql.DB, fn func(c *conn) error) error {
+	sqlConn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer sqlConn.Close()
+
+	return sqlConn.Raw(func(driverConn interface{}) error {
+		c, ok := driverConn.(*conn)
+		if !ok {
+			return fmt.Errorf("db uses %T, not the athena driver", driverConn)
+		}
+		return fn(c)
+	})
+}
+
+func (c *conn) fetchPage(ctx context.Context, pos pageCursor, size int64) (*Page, error) {
+	statusResp, err := c.athena.GetQueryExecutionWithContext(ctx, &athena.GetQueryExecutionInput{
+		QueryExecutionId: aws.String(pos.QueryID),
+	})
+	if err != nil {
+		return nil, err
+	}
+	exec := statusResp.QueryExecution
+	if state := aws.StringValue(exec.Status.State); state != athena.QueryExecutionStateSucceeded {
+		return nil, fmt.Errorf("query %s is %s", pos.QueryID, state)
+	}
+	skipHeader := hasHeaderRow(exec)
+
+	page := &Page{}
+	for int64(len(page.Rows)) < size {
+		firstPage := pos.NextToken == ""
+		if pos.Offset == 0 {
+			// the page is fetched from its start, so its size can be picked
+			// freely
+			pos.PageSize = size - int64(len(page.Rows))
+			if firstPage && skipHeader {
+				pos.PageSize = min(pos.PageSize+1, maxResultCnt)
+				pos.Offset = 1
+			}
+		}
+
+		input := &athena.GetQueryResultsInput{
+			QueryExecutionId: aws.String(pos.QueryID),
+			MaxResults:       aws.Int64(pos.PageSize),
+		}
+		if !firstPage {
+			input.NextToken = aws.String(pos.NextToken)
+		}
+		var out *athena.GetQueryResultsOutput
+		err := c.retry.do(ctx, "GetQueryResults", pos.QueryID, func() error {
+			var err error
+			out, err = c.athena.GetQueryResultsWithContext(ctx, input)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		if out.ResultSet == nil {
+			return page, nil
+		}
+		if page.Columns == nil && out.ResultSet.ResultSetMetadata != nil {
+			page.Columns = out.ResultSet.ResultSetMetadata.ColumnInfo
+		}
+
+		results := out.ResultSet.Rows
+		if pos.Offset > int64(len(results)) {
+			pos.Offset = int64(len(results))
+		}
+		results = results[pos.Offset:]
+		if need := size - int64(len(page.Rows)); int64(len(results)) > need {
+			results = results[:need]
+		}
+		for _, row := range results {
+			dest := make([]driver.Value, len(page.Columns))
+			if err := convertRow(page.Columns, row.Data, dest, c.loc); err != nil {
+				return nil, err
+			}
+			values := make([]interface{}, len(dest))
+			for i := range dest {
+				values[i] = dest[i]
+			}
+			page.Rows = append(page.Rows, values)
+		}
+
+		pos.Offset += int64(len(results))
+		if pos.Offset < int64(len(out.ResultSet.Rows)) {
+			// the page was only partly consumed
+			break
+		}
+		if out.NextToken == nil || *out.NextToken == "" {
+			return page, nil
+		}
+		pos.NextToken, pos.Offset = *out.NextToken, 0
+	}
+
+	page.Cursor = pos.encode()
+	return page, nil
+}
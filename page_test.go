@@ -0,0 +1,88 @@
+package athena
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/aws/aws-sdk-go/service/athena/athenaiface"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// pagingAthenaClient serves a header row and rowCnt integer rows, honouring
+// MaxResults and using the offset of the next row as NextToken.
+type pagingAthenaClient struct {
+	athenaiface.AthenaAPI
+	rowCnt int
+}
+
+func (m *pagingAthenaClient) GetQueryExecutionWithContext(aws.Context, *athena.GetQueryExecutionInput, ...request.Option) (*athena.GetQueryExecutionOutput, error) {
+	return &athena.GetQueryExecutionOutput{QueryExecution: &athena.QueryExecution{
+		StatementType: aws.String(athena.StatementTypeDml),
+		Status:        &athena.QueryExecutionStatus{State: aws.String(athena.QueryExecutionStateSucceeded)},
+	}}, nil
+}
+
+func (m *pagingAthenaClient) GetQueryResultsWithContext(_ aws.Context, input *athena.GetQueryResultsInput, _ ...request.Option) (*athena.GetQueryResultsOutput, error) {
+	start := 0
+	if input.NextToken != nil {
+		start, _ = strconv.Atoi(*input.NextToken)
+	}
+	end := min(start+int(*input.MaxResults), m.rowCnt+1)
+
+	out := &athena.GetQueryResultsOutput{ResultSet: &athena.ResultSet{
+		ResultSetMetadata: &athena.ResultSetMetadata{ColumnInfo: []*athena.ColumnInfo{
+			{Name: aws.String("n"), Type: aws.String("integer")},
+		}},
+	}}
+	for i := start; i < end; i++ {
+		val := "n"
+		if i > 0 {
+			val = strconv.Itoa(i)
+		}
+		out.ResultSet.Rows = append(out.ResultSet.Rows, &athena.Row{Data: []*athena.Datum{{VarCharValue: aws.String(val)}}})
+	}
+	if end <= m.rowCnt {
+		out.NextToken = aws.String(strconv.Itoa(end))
+	}
+	return out, nil
+}
+
+func TestConn_FetchPage(t *testing.T) {
+	c := &conn{athena: &pagingAthenaClient{rowCnt: 7}}
+
+	for _, sizes := range [][]int64{{3}, {2, 5, 1}, {7}, {1000}} {
+		var (
+			got    []interface{}
+			cursor string
+		)
+		for i := 0; ; i++ {
+			pos := pageCursor{QueryID: "query-1"}
+			if cursor != "" {
+				var err error
+				pos, err = decodePageCursor(cursor)
+				require.NoError(t, err)
+			}
+			size := sizes[i%len(sizes)]
+			page, err := c.fetchPage(context.Background(), pos, size)
+			require.NoError(t, err)
+			assert.LessOrEqual(t, int64(len(page.Rows)), size)
+			for _, row := range page.Rows {
+				got = append(got, row[0])
+			}
+			cursor = page.Cursor
+			if cursor == "" {
+				break
+			}
+		}
+		assert.Equal(t, []interface{}{int64(1), int64(2), int64(3), int64(4), int64(5), int64(6), int64(7)}, got, fmt.Sprint(sizes))
+	}
+
+	_, err := decodePageCursor("not a cursor")
+	assert.Error(t, err)
+}
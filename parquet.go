@@ -0,0 +1,255 @@
+package athena
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/apache/arrow/go/v15/arrow"
+	"github.com/apache/arrow/go/v15/arrow/array"
+	"github.com/apache/arrow/go/v15/arrow/memory"
+	"github.com/apache/arrow/go/v15/parquet/file"
+	"github.com/apache/arrow/go/v15/parquet/pqarrow"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/athena"
+)
+
+// parquetBatchSize is the number of rows decoded at once from Parquet files.
+const parquetBatchSize = 1024
+
+// openParquetFile opens an unloaded Parquet file. Parquet files are read
+// backwards from their footer, so the file is downloaded whole first. The
+// columns of the rows are taken from the schema of the first file opened.
+func (r *unloadRows) openParquetFile(key string) error {
+	body, err := r.getObject(key)
+	if err != nil {
+		return err
+	}
+	b, err := io.ReadAll(body)
+	body.Close()
+	if err != nil {
+		return err
+	}
+
+	pf, err := file.NewParquetReader(bytes.NewReader(b))
+	if err != nil {
+		return fmt.Errorf("read parquet file %s: %w", key, err)
+	}
+	fr, err := pqarrow.NewFileReader(pf, pqarrow.ArrowReadProperties{BatchSize: parquetBatchSize}, memory.DefaultAllocator)
+	if err != nil {
+		return fmt.Errorf("read parquet file %s: %w", key, err)
+	}
+	schema, err := fr.Schema()
+	if err != nil {
+		return fmt.Errorf("read parquet file %s: %w", key, err)
+	}
+	columns, err := parquetColumns(schema)
+	if err != nil {
+		return fmt.Errorf("read parquet file %s: %w", key, err)
+	}
+	if r.columns == nil {
+		r.columns = columns
+	} else if len(columns) != len(r.columns) {
+		return fmt.Errorf("parquet file %s has %d columns, expected %d", key, len(columns), len(r.columns))
+	}
+
+	reader, err := fr.GetRecordReader(r.ctx, nil, nil)
+	if err != nil {
+		return fmt.Errorf("read parquet file %s: %w", key, err)
+	}
+	r.file = &parquetUnloadFile{reader: reader, columns: r.columns, loc: r.loc}
+	return nil
+}
+
+// parquetColumns returns the column metadata of the Parquet schema.
+func parquetColumns(schema *arrow.Schema) ([]*athena.ColumnInfo, error) {
+	columns := make([]*athena.ColumnInfo, 0, len(schema.Fields()))
+	for _, field := range schema.Fields() {
+		athenaType, err := athenaTypeOf(field.Type)
+		if err != nil {
+			return nil, fmt.Errorf("column %s: %w", field.Name, err)
+		}
+		column := &athena.ColumnInfo{
+			Name:     aws.String(field.Name),
+			Label:    aws.String(field.Name),
+			Type:     aws.String(athenaType),
+			Nullable: aws.String(athena.ColumnNullableNullable),
+		}
+		if !field.Nullable {
+			column.Nullable = aws.String(athena.ColumnNullableNotNull)
+		}
+		if dt, ok := field.Type.(*arrow.Decimal128Type); ok {
+			// athenaType keeps only the name of decimals, like the column
+			// metadata of query results
+			column.Precision = aws.Int64(int64(dt.Precision))
+			column.Scale = aws.Int64(int64(dt.Scale))
+		}
+		columns = append(columns, column)
+	}
+	return columns, nil
+}
+
+// athenaTypeOf returns the Athena type of values of the Arrow type dt.
+func athenaTypeOf(dt arrow.DataType) (string, error) {
+	switch dt := dt.(type) {
+	case *arrow.Int8Type:
+		return "tinyint", nil
+	case *arrow.Int16Type:
+		return "smallint", nil
+	case *arrow.Int32Type:
+		return "integer", nil
+	case *arrow.Int64Type:
+		return "bigint", nil
+	case *arrow.Float32Type:
+		return "real", nil
+	case *arrow.Float64Type:
+		return "double", nil
+	case *arrow.BooleanType:
+		return "boolean", nil
+	case *arrow.StringType, *arrow.LargeStringType:
+		return "varchar", nil
+	case *arrow.BinaryType, *arrow.LargeBinaryType, *arrow.FixedSizeBinaryType:
+		return "varbinary", nil
+	case *arrow.Date32Type:
+		return "date", nil
+	case *arrow.TimestampType:
+		return "timestamp", nil
+	case *arrow.Decimal128Type:
+		return "decimal", nil
+	case *arrow.MapType:
+		key, err := athenaTypeOf(dt.KeyType())
+		if err != nil {
+			return "", err
+		}
+		value, err := athenaTypeOf(dt.ItemType())
+		if err != nil {
+			return "", err
+		}
+		return "map(" + key + ", " + value + ")", nil
+	case *arrow.ListType:
+		elem, err := athenaTypeOf(dt.Elem())
+		if err != nil {
+			return "", err
+		}
+		return "array(" + elem + ")", nil
+	case *arrow.StructType:
+		fields := make([]string, 0, len(dt.Fields()))
+		for _, field := range dt.Fields() {
+			fieldType, err := athenaTypeOf(field.Type)
+			if err != nil {
+				return "", err
+			}
+			fields = append(fields, field.Name+" "+fieldType)
+		}
+		return "row(" + strings.Join(fields, ", ") + ")", nil
+	default:
+		return "", fmt.Errorf("unsupported parquet type %s", dt)
+	}
+}
+
+// parquetUnloadFile reads a Parquet file through the Arrow records decoded
+// from it.
+type parquetUnloadFile struct {
+	reader  pqarrow.RecordReader
+	columns []*athena.ColumnInfo
+	loc     *time.Location
+
+	rec arrow.Record
+	row int
+}
+
+func (f *parquetUnloadFile) next(dest []driver.Value) error {
+	for f.rec == nil || f.row >= int(f.rec.NumRows()) {
+		if !f.reader.Next() {
+			if err := f.reader.Err(); err != nil && err != io.EOF {
+				return err
+			}
+			return io.EOF
+		}
+		f.rec, f.row = f.reader.Record(), 0
+	}
+
+	for i, col := range f.rec.Columns() {
+		var err error
+		dest[i], err = convertArrowValue(aws.StringValue(f.columns[i].Type), col, f.row, f.loc)
+		if err != nil {
+			return err
+		}
+	}
+	f.row++
+	return nil
+}
+
+func (f *parquetUnloadFile) close() {
+	f.reader.Release()
+}
+
+// convertArrowValue converts the value at i of arr. Scalars are rendered as
+// Athena does and converted like query results, arrays, maps and rows are
+// returned as JSON like those unloaded to JSON.
+func convertArrowValue(athenaType string, arr arrow.Array, i int, loc *time.Location) (interface{}, error) {
+	if arr.IsNull(i) {
+		return nil, nil
+	}
+
+	var text string
+	switch arr := arr.(type) {
+	case *array.Map, *array.List, *array.Struct:
+		return json.Marshal(arrowJSONValue(arr, i))
+	case *array.Binary:
+		return bytes.Clone(arr.Value(i)), nil
+	case *array.LargeBinary:
+		return bytes.Clone(arr.Value(i)), nil
+	case *array.FixedSizeBinary:
+		return bytes.Clone(arr.Value(i)), nil
+	case *array.Date32:
+		text = arr.Value(i).ToTime().Format(DateLayout)
+	case *array.Timestamp:
+		unit := arr.DataType().(*arrow.TimestampType).Unit
+		text = arr.Value(i).ToTime(unit).Format("2006-01-02 15:04:05.999999999")
+	case *array.Decimal128:
+		text = arr.Value(i).ToString(arr.DataType().(*arrow.Decimal128Type).Scale)
+	default:
+		text = arr.ValueStr(i)
+	}
+	return convertValue(athenaType, &text, loc)
+}
+
+// arrowJSONValue returns the value at i of arr to be marshaled to JSON, with
+// maps as objects keyed by the text of their keys.
+func arrowJSONValue(arr arrow.Array, i int) interface{} {
+	if arr.IsNull(i) {
+		return nil
+	}
+	switch arr := arr.(type) {
+	case *array.Map:
+		start, end := arr.ValueOffsets(i)
+		keys, items := arr.Keys(), arr.Items()
+		m := make(map[string]interface{}, end-start)
+		for j := int(start); j < int(end); j++ {
+			m[keys.ValueStr(j)] = arrowJSONValue(items, j)
+		}
+		return m
+	case *array.List:
+		start, end := arr.ValueOffsets(i)
+		values := arr.ListValues()
+		list := make([]interface{}, 0, end-start)
+		for j := int(start); j < int(end); j++ {
+			list = append(list, arrowJSONValue(values, j))
+		}
+		return list
+	case *array.Struct:
+		fields := arr.DataType().(*arrow.StructType).Fields()
+		m := make(map[string]interface{}, len(fields))
+		for f, field := range fields {
+			m[field.Name] = arrowJSONValue(arr.Field(f), i)
+		}
+		return m
+	default:
+		return arr.GetOneForMarshal(i)
+	}
+}
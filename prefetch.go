@@ -0,0 +1,74 @@
+package athena
+
+import (
+	"context"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/service/athena"
+)
+
+type pageFetcher func(ctx context.Context, token *string) (*athena.GetQueryResultsOutput, error)
+
+type prefetchedPage struct {
+	out *athena.GetQueryResultsOutput
+	err error
+}
+
+// prefetcher fetches result pages in a background goroutine, staying at most
+// depth pages ahead of the consumer. Pages are delivered in order.
+type prefetcher struct {
+	pages  chan prefetchedPage
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// startPrefetch starts fetching pages from token onwards.
+func startPrefetch(ctx context.Context, depth int, fetch pageFetcher, token *string) *prefetcher {
+	ctx, cancel := context.WithCancel(ctx)
+	p := &prefetcher{
+		// the goroutine holds one more page while blocked on sending
+		pages:  make(chan prefetchedPage, depth-1),
+		cancel: cancel,
+	}
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		defer close(p.pages)
+		for token != nil && *token != "" {
+			out, err := fetch(ctx, token)
+			select {
+			case p.pages <- prefetchedPage{out: out, err: err}:
+			case <-ctx.Done():
+				return
+			}
+			if err != nil || out == nil {
+				return
+			}
+			token = out.NextToken
+		}
+	}()
+	return p
+}
+
+// next returns the next page, blocking until it is fetched.
+func (p *prefetcher) next(ctx context.Context) (*athena.GetQueryResultsOutput, error) {
+	select {
+	case page, ok := <-p.pages:
+		if !ok {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			return nil, context.Canceled
+		}
+		return page.out, page.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// stop cancels any in-flight fetch and waits for the goroutine to exit.
+func (p *prefetcher) stop() {
+	p.cancel()
+	p.wg.Wait()
+}
@@ -0,0 +1,323 @@
+package athena
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/aws/aws-sdk-go/service/athena/athenaiface"
+)
+
+// ErrCodeRecordingNotFound is the code of the awserr.Error a Replayer returns
+// for a query, execution or result page missing from its golden file.
+const ErrCodeRecordingNotFound = "RecordingNotFound"
+
+// recording is the content of a golden file.
+type recording struct {
+	Interactions []*interaction `json:"interactions"`
+}
+
+// interaction is a recorded query with its final execution and the result
+// pages fetched for it, keyed by their NextToken.
+type interaction struct {
+	Query     string                                   `json:"query"`
+	Params    []string                                 `json:"params,omitempty"`
+	Execution *athena.QueryExecution                   `json:"execution,omitempty"`
+	Pages     map[string]*athena.GetQueryResultsOutput `json:"pages,omitempty"`
+}
+
+// Recorder wraps an Athena client, e.g. a real one, and records the queries
+// started on it, their final execution and their result pages. Save writes
+// them to a golden file that a Replayer serves back offline.
+type Recorder struct {
+	athenaiface.AthenaAPI
+
+	path string
+
+	mu           sync.Mutex
+	interactions []*interaction
+	byID         map[string]*interaction
+}
+
+// NewRecorder returns a Recorder running queries on client and recording
+// them to the golden file at path.
+func NewRecorder(client athenaiface.AthenaAPI, path string) *Recorder {
+	return &Recorder{AthenaAPI: client, path: path, byID: make(map[string]*interaction)}
+}
+
+// Save writes the recorded interactions to the golden file.
+func (r *Recorder) Save() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	b, err := json.MarshalIndent(recording{Interactions: r.interactions}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(r.path, append(b, '\n'), 0o644)
+}
+
+func (r *Recorder) StartQueryExecution(input *athena.StartQueryExecutionInput) (*athena.StartQueryExecutionOutput, error) {
+	out, err := r.AthenaAPI.StartQueryExecution(input)
+	if err != nil {
+		return nil, err
+	}
+	r.start(input, out)
+	return out, nil
+}
+
+func (r *Recorder) StartQueryExecutionWithContext(ctx aws.Context, input *athena.StartQueryExecutionInput, opts ...request.Option) (*athena.StartQueryExecutionOutput, error) {
+	out, err := r.AthenaAPI.StartQueryExecutionWithContext(ctx, input, opts...)
+	if err != nil {
+		return nil, err
+	}
+	r.start(input, out)
+	return out, nil
+}
+
+func (r *Recorder) start(input *athena.StartQueryExecutionInput, out *athena.StartQueryExecutionOutput) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i := &interaction{
+		Query:  normalizeSQL(aws.StringValue(input.QueryString)),
+		Params: aws.StringValueSlice(input.ExecutionParameters),
+		Pages:  make(map[string]*athena.GetQueryResultsOutput),
+	}
+	r.interactions = append(r.interactions, i)
+	r.byID[aws.StringValue(out.QueryExecutionId)] = i
+}
+
+func (r *Recorder) GetQueryExecution(input *athena.GetQueryExecutionInput) (*athena.GetQueryExecutionOutput, error) {
+	out, err := r.AthenaAPI.GetQueryExecution(input)
+	if err != nil {
+		return nil, err
+	}
+	r.execution(out)
+	return out, nil
+}
+
+func (r *Recorder) GetQueryExecutionWithContext(ctx aws.Context, input *athena.GetQueryExecutionInput, opts ...request.Option) (*athena.GetQueryExecutionOutput, error) {
+	out, err := r.AthenaAPI.GetQueryExecutionWithContext(ctx, input, opts...)
+	if err != nil {
+		return nil, err
+	}
+	r.execution(out)
+	return out, nil
+}
+
+// execution records the execution of a query once it finished.
+func (r *Recorder) execution(out *athena.GetQueryExecutionOutput) {
+	exec := out.QueryExecution
+	if exec == nil || exec.Status == nil || !isFinalState(aws.StringValue(exec.Status.State)) {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if i, ok := r.byID[aws.StringValue(exec.QueryExecutionId)]; ok {
+		i.Execution = exec
+	}
+}
+
+func (r *Recorder) GetQueryResults(input *athena.GetQueryResultsInput) (*athena.GetQueryResultsOutput, error) {
+	out, err := r.AthenaAPI.GetQueryResults(input)
+	if err != nil {
+		return nil, err
+	}
+	r.page(input, out)
+	return out, nil
+}
+
+func (r *Recorder) GetQueryResultsWithContext(ctx aws.Context, input *athena.GetQueryResultsInput, opts ...request.Option) (*athena.GetQueryResultsOutput, error) {
+	out, err := r.AthenaAPI.GetQueryResultsWithContext(ctx, input, opts...)
+	if err != nil {
+		return nil, err
+	}
+	r.page(input, out)
+	return out, nil
+}
+
+func (r *Recorder) page(input *athena.GetQueryResultsInput, out *athena.GetQueryResultsOutput) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if i, ok := r.byID[aws.StringValue(input.QueryExecutionId)]; ok {
+		i.Pages[aws.StringValue(input.NextToken)] = copyResults(out)
+	}
+}
+
+// Replayer is an Athena client serving the queries recorded by a Recorder.
+// Queries are matched by their normalized SQL and execution parameters; a
+// query recorded several times is replayed in recording order. Results are
+// served in the pages they were recorded in, whatever page size is asked for.
+// Anything missing from the golden file fails with an awserr.Error of code
+// ErrCodeRecordingNotFound.
+//
+// Only the methods used by the driver are implemented, calling other ones
+// panics.
+type Replayer struct {
+	athenaiface.AthenaAPI
+
+	mu           sync.Mutex
+	interactions []*interaction
+	replayed     map[*interaction]bool
+	queries      map[string]*interaction
+}
+
+// NewReplayer returns a Replayer serving the golden file at path.
+func NewReplayer(path string) (*Replayer, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rec recording
+	if err := json.Unmarshal(b, &rec); err != nil {
+		return nil, fmt.Errorf("parse golden file %s: %w", path, err)
+	}
+	return &Replayer{
+		interactions: rec.Interactions,
+		replayed:     make(map[*interaction]bool),
+		queries:      make(map[string]*interaction),
+	}, nil
+}
+
+func (r *Replayer) StartQueryExecution(input *athena.StartQueryExecutionInput) (*athena.StartQueryExecutionOutput, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	query := normalizeSQL(aws.StringValue(input.QueryString))
+	params := aws.StringValueSlice(input.ExecutionParameters)
+	var match *interaction
+	for _, i := range r.interactions {
+		if i.Query != query || !slices.Equal(i.Params, params) {
+			continue
+		}
+		match = i
+		if !r.replayed[i] {
+			break
+		}
+	}
+	if match == nil {
+		return nil, awserr.New(ErrCodeRecordingNotFound,
+			fmt.Sprintf("no recorded interaction for query %q with params %q", query, params), nil)
+	}
+
+	r.replayed[match] = true
+	queryID := "replay-query-" + strconv.Itoa(len(r.queries)+1)
+	r.queries[queryID] = match
+	return &athena.StartQueryExecutionOutput{QueryExecutionId: aws.String(queryID)}, nil
+}
+
+func (r *Replayer) StartQueryExecutionWithContext(_ aws.Context, input *athena.StartQueryExecutionInput, _ ...request.Option) (*athena.StartQueryExecutionOutput, error) {
+	return r.StartQueryExecution(input)
+}
+
+func (r *Replayer) lookup(queryID *string) (*interaction, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	i, ok := r.queries[aws.StringValue(queryID)]
+	if !ok {
+		return nil, awserr.New(athena.ErrCodeInvalidRequestException,
+			fmt.Sprintf("QueryExecution %s was not found", aws.StringValue(queryID)), nil)
+	}
+	return i, nil
+}
+
+func (r *Replayer) GetQueryExecution(input *athena.GetQueryExecutionInput) (*athena.GetQueryExecutionOutput, error) {
+	i, err := r.lookup(input.QueryExecutionId)
+	if err != nil {
+		return nil, err
+	}
+	if i.Execution == nil {
+		return nil, awserr.New(ErrCodeRecordingNotFound, fmt.Sprintf("no recorded execution for query %q", i.Query), nil)
+	}
+	exec := *i.Execution
+	exec.QueryExecutionId = input.QueryExecutionId
+	return &athena.GetQueryExecutionOutput{QueryExecution: &exec}, nil
+}
+
+func (r *Replayer) GetQueryExecutionWithContext(_ aws.Context, input *athena.GetQueryExecutionInput, _ ...request.Option) (*athena.GetQueryExecutionOutput, error) {
+	return r.GetQueryExecution(input)
+}
+
+func (r *Replayer) StopQueryExecution(input *athena.StopQueryExecutionInput) (*athena.StopQueryExecutionOutput, error) {
+	if _, err := r.lookup(input.QueryExecutionId); err != nil {
+		return nil, err
+	}
+	return &athena.StopQueryExecutionOutput{}, nil
+}
+
+func (r *Replayer) StopQueryExecutionWithContext(_ aws.Context, input *athena.StopQueryExecutionInput, _ ...request.Option) (*athena.StopQueryExecutionOutput, error) {
+	return r.StopQueryExecution(input)
+}
+
+func (r *Replayer) GetQueryResults(input *athena.GetQueryResultsInput) (*athena.GetQueryResultsOutput, error) {
+	i, err := r.lookup(input.QueryExecutionId)
+	if err != nil {
+		return nil, err
+	}
+	page, ok := i.Pages[aws.StringValue(input.NextToken)]
+	if !ok {
+		return nil, awserr.New(ErrCodeRecordingNotFound,
+			fmt.Sprintf("no recorded result page for query %q at token %q", i.Query, aws.StringValue(input.NextToken)), nil)
+	}
+	return copyResults(page), nil
+}
+
+func (r *Replayer) GetQueryResultsWithContext(_ aws.Context, input *athena.GetQueryResultsInput, _ ...request.Option) (*athena.GetQueryResultsOutput, error) {
+	return r.GetQueryResults(input)
+}
+
+// copyResults copies the rows of out, which the driver consumes as it reads
+// them.
+func copyResults(out *athena.GetQueryResultsOutput) *athena.GetQueryResultsOutput {
+	cp := *out
+	if out.ResultSet != nil {
+		resultSet := *out.ResultSet
+		resultSet.Rows = slices.Clone(resultSet.Rows)
+		cp.ResultSet = &resultSet
+	}
+	return &cp
+}
+
+// normalizeSQL trims query and collapses its whitespace outside of quoted
+// strings and identifiers, and drops a trailing semicolon.
+func normalizeSQL(query string) string {
+	var (
+		b     strings.Builder
+		quote rune
+		space bool
+	)
+	for _, c := range strings.TrimSpace(query) {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			space = true
+			continue
+		}
+		if space {
+			b.WriteByte(' ')
+			space = false
+		}
+		b.WriteRune(c)
+	}
+	return strings.TrimSpace(strings.TrimSuffix(b.String(), ";"))
+}
+
+var (
+	_ athenaiface.AthenaAPI = (*Recorder)(nil)
+	_ athenaiface.AthenaAPI = (*Replayer)(nil)
+)
@@ -0,0 +1,88 @@
+package athena
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeSQL(t *testing.T) {
+	tests := map[string]string{
+		"SELECT 1":                           "SELECT 1",
+		"  SELECT\n\t1 ;\n":                  "SELECT 1",
+		"SELECT  a,\n  b FROM t WHERE c = ?": "SELECT a, b FROM t WHERE c = ?",
+		"SELECT 'a  b',  \"x  y\"":           "SELECT 'a  b', \"x  y\"",
+	}
+	for in, expected := range tests {
+		assert.Equal(t, expected, normalizeSQL(in), in)
+	}
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	fake := NewFakeAthena()
+	result := FakeResult{Columns: []FakeColumn{{Name: "n", Type: "integer"}}}
+	for i := 1; i <= 5; i++ {
+		result.Rows = append(result.Rows, []interface{}{i})
+	}
+	fake.RegisterRegexp(`^SELECT n\s+FROM t WHERE n > \?$`, result)
+	fake.Register("SELECT broken", FakeResult{FailureReason: "SYNTAX_ERROR: line 1:8"})
+
+	run := func(db *sql.DB) ([]int, error) {
+		rows, err := db.QueryContext(WithPageSize(context.Background(), 2), "SELECT n\n  FROM t WHERE n > ?", 0)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		var got []int
+		for rows.Next() {
+			var n int
+			if err := rows.Scan(&n); err != nil {
+				return nil, err
+			}
+			got = append(got, n)
+		}
+		return got, rows.Err()
+	}
+
+	path := filepath.Join(t.TempDir(), "golden.json")
+	recorder := NewRecorder(fake, path)
+	db := openMockDB(t, recorder)
+	got, err := run(db)
+	require.NoError(t, err)
+	assert.Equal(t, []int{1, 2, 3, 4, 5}, got)
+	_, err = db.Query("SELECT broken")
+	require.Error(t, err)
+	require.NoError(t, recorder.Save())
+
+	replayer, err := NewReplayer(path)
+	require.NoError(t, err)
+	db = openMockDB(t, replayer)
+	for i := 0; i < 2; i++ {
+		got, err = run(db)
+		require.NoError(t, err)
+		assert.Equal(t, []int{1, 2, 3, 4, 5}, got)
+	}
+
+	_, err = db.Query("SELECT broken;")
+	var failed *QueryFailedError
+	require.ErrorAs(t, err, &failed)
+	assert.Equal(t, "SYNTAX_ERROR: line 1:8", failed.Reason)
+
+	_, err = db.Query("SELECT n FROM t WHERE n > ?", 1)
+	var aerr awserr.Error
+	require.ErrorAs(t, err, &aerr)
+	assert.Equal(t, ErrCodeRecordingNotFound, aerr.Code())
+	assert.Equal(t, `no recorded interaction for query "SELECT n FROM t WHERE n > ?" with params ["1"]`, aerr.Message())
+	assert.False(t, isTransientError(err))
+
+	_, err = replayer.GetQueryResults(&athena.GetQueryResultsInput{QueryExecutionId: aws.String("replay-query-1"), NextToken: aws.String("9")})
+	require.ErrorAs(t, err, &aerr)
+	assert.Equal(t, ErrCodeRecordingNotFound, aerr.Code())
+}
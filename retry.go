@@ -0,0 +1,89 @@
+package athena
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/athena"
+)
+
+var (
+	defaultPageRetries     = 3
+	defaultRetryBackoff    = 200 * time.Millisecond
+	defaultMaxRetryBackoff = 5 * time.Second
+)
+
+// RetryInfo describes a failed request that is about to be retried.
+type RetryInfo struct {
+	// Operation is the Athena API operation, e.g. "GetQueryResults".
+	Operation string
+	QueryID   string
+	// Attempt is the number of the retry, starting at 1.
+	Attempt int
+	// Delay is how long the driver waits before retrying.
+	Delay time.Duration
+	// Err is the error of the failed request.
+	Err error
+}
+
+// RetryHook is called before a failed request is retried. It's useful for
+// logging and metrics.
+type RetryHook func(ctx context.Context, info RetryInfo)
+
+// retryPolicy retries requests failing with transient errors, e.g.
+// throttling or a reset connection, with exponential backoff.
+type retryPolicy struct {
+	maxRetries int
+	backoff    time.Duration
+	maxBackoff time.Duration
+	hook       RetryHook
+}
+
+// do calls fn until it succeeds, fails with a non transient error or the
+// retries are exhausted.
+func (p retryPolicy) do(ctx context.Context, operation, queryID string, fn func() error) error {
+	delay := p.backoff
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt > p.maxRetries || !isTransientError(err) {
+			return err
+		}
+
+		if p.hook != nil {
+			p.hook(ctx, RetryInfo{
+				Operation: operation,
+				QueryID:   queryID,
+				Attempt:   attempt,
+				Delay:     delay,
+				Err:       err,
+			})
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		if delay *= 2; delay > p.maxBackoff {
+			delay = p.maxBackoff
+		}
+	}
+}
+
+// isTransientError reports whether err is an AWS error worth retrying, e.g.
+// throttling, a 5xx response or a reset connection.
+func isTransientError(err error) bool {
+	var aerr awserr.Error
+	if !errors.As(err, &aerr) || aerr.Code() == request.CanceledErrorCode {
+		return false
+	}
+	var reqErr awserr.RequestFailure
+	if errors.As(err, &reqErr) && reqErr.StatusCode() >= 500 {
+		return true
+	}
+	return aerr.Code() == athena.ErrCodeTooManyRequestsException ||
+		request.IsErrorThrottle(err) ||
+		request.IsErrorRetryable(err)
+}
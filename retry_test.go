@@ -0,0 +1,82 @@
+package athena
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// flakyAthenaClient fails the first failures requests for a later page.
+type flakyAthenaClient struct {
+	mockAthenaClient
+	failures int
+	err      error
+}
+
+func (m *flakyAthenaClient) GetQueryResultsWithContext(ctx aws.Context, query *athena.GetQueryResultsInput, _ ...request.Option) (*athena.GetQueryResultsOutput, error) {
+	if query.NextToken != nil && m.failures > 0 {
+		m.failures--
+		return nil, m.err
+	}
+	return m.mockAthenaClient.GetQueryResultsWithContext(ctx, query)
+}
+
+func TestRows_RetryPageFetch(t *testing.T) {
+	throttled := awserr.New(athena.ErrCodeTooManyRequestsException, "rate exceeded", nil)
+	var retries []RetryInfo
+	policy := retryPolicy{
+		maxRetries: 2,
+		backoff:    time.Millisecond,
+		maxBackoff: time.Millisecond,
+		hook: func(_ context.Context, info RetryInfo) {
+			retries = append(retries, info)
+		},
+	}
+
+	r, err := newRows(context.Background(), rowsConfig{
+		Athena:     &flakyAthenaClient{failures: 2, err: throttled},
+		QueryID:    "select",
+		SkipHeader: true,
+		Retry:      policy,
+	})
+	require.NoError(t, err)
+	cnt := 0
+	for r.Next(make([]driver.Value, 2)) == nil {
+		cnt++
+	}
+	assert.Equal(t, 9, cnt)
+	require.Len(t, retries, 2)
+	assert.Equal(t, RetryInfo{Operation: "GetQueryResults", QueryID: "select", Attempt: 2, Delay: time.Millisecond, Err: throttled}, retries[1])
+
+	// retries are exhausted
+	r, err = newRows(context.Background(), rowsConfig{
+		Athena:     &flakyAthenaClient{failures: 3, err: throttled},
+		QueryID:    "select",
+		SkipHeader: true,
+		Retry:      policy,
+	})
+	require.NoError(t, err)
+	for err == nil {
+		err = r.Next(make([]driver.Value, 2))
+	}
+	assert.Equal(t, throttled, err)
+}
+
+func TestIsTransientError(t *testing.T) {
+	assert.True(t, isTransientError(awserr.New("ThrottlingException", "slow down", nil)))
+	assert.True(t, isTransientError(awserr.NewRequestFailure(awserr.New("InternalServerException", "oops", nil), 503, "id")))
+	assert.True(t, isTransientError(awserr.New("RequestError", "send request failed", errors.New("read: connection reset by peer"))))
+	assert.False(t, isTransientError(awserr.NewRequestFailure(awserr.New(athena.ErrCodeInvalidRequestException, "bad token", nil), 400, "id")))
+	assert.False(t, isTransientError(io.ErrUnexpectedEOF))
+	assert.False(t, isTransientError(nil))
+}
@@ -1,8 +1,10 @@
 package athena
 
 import (
+	"context"
 	"database/sql/driver"
 	"io"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/athena"
@@ -10,68 +12,96 @@ import (
 )
 
 const (
+	// maxResultCnt is the maximum page size GetQueryResults accepts.
 	maxResultCnt = 1000
 )
 
 type rows struct {
-	athena  athenaiface.AthenaAPI
-	queryID string
-
-	done          bool
-	skipHeaderRow bool
-	out           *athena.GetQueryResultsOutput
+	athena   athenaiface.AthenaAPI
+	queryID  string
+	loc      *time.Location
+	ctx      context.Context
+	cancel   context.CancelFunc
+	prefetch *prefetcher
+	pageSize int64
+	retry    retryPolicy
+
+	done           bool
+	skipHeaderRow  bool
+	utilityColumns []string
+	splitUtility   bool
+	out            *athena.GetQueryResultsOutput
+	// page holds the rows of out left to read. out itself isn't changed as
+	// clients, e.g. mocks, may return the same output again.
+	page []*athena.Row
+	resultColumns
 }
 
 type rowsConfig struct {
 	Athena     athenaiface.AthenaAPI
 	QueryID    string
 	SkipHeader bool
+	Location   *time.Location
+	// PageSize is the number of rows requested per GetQueryResults call,
+	// including the header row on the first page. Defaults to maxResultCnt.
+	PageSize int64
+	// Retry retries page fetches failing with transient errors.
+	Retry retryPolicy
+	// PrefetchDepth is the number of result pages fetched ahead in the
+	// background while the current page is consumed. Zero disables it.
+	PrefetchDepth int
+	// UtilityColumns names the columns of a DDL or utility statement whose
+	// result is returned as a single tab-separated column.
+	UtilityColumns []string
 }
 
-func newRows(cfg rowsConfig) (*rows, error) {
+func newRows(ctx context.Context, cfg rowsConfig) (*rows, error) {
+	if cfg.PageSize == 0 {
+		cfg.PageSize = maxResultCnt
+	}
+	// fetches are bound to the rows so closing them aborts an in-flight one
+	ctx, cancel := context.WithCancel(ctx)
 	r := rows{
-		athena:        cfg.Athena,
-		queryID:       cfg.QueryID,
-		skipHeaderRow: cfg.SkipHeader,
+		ctx:            ctx,
+		cancel:         cancel,
+		athena:         cfg.Athena,
+		pageSize:       cfg.PageSize,
+		retry:          cfg.Retry,
+		queryID:        cfg.QueryID,
+		loc:            cfg.Location,
+		skipHeaderRow:  cfg.SkipHeader,
+		utilityColumns: cfg.UtilityColumns,
 	}
 
 	shouldContinue, err := r.fetchNextPage(nil)
 	if err != nil {
+		cancel()
 		return nil, err
 	}
 
 	r.done = !shouldContinue
-	return &r, nil
-}
-
-func (r *rows) Columns() []string {
-	var columns []string
-	for _, colInfo := range r.out.ResultSet.ResultSetMetadata.ColumnInfo {
-		columns = append(columns, *colInfo.Name)
+	if !r.done && cfg.PrefetchDepth > 0 && r.hasNextPage() {
+		r.prefetch = startPrefetch(ctx, cfg.PrefetchDepth, r.getQueryResults, r.out.NextToken)
 	}
-
-	return columns
-}
-
-func (r *rows) ColumnTypeDatabaseTypeName(index int) string {
-	colInfo := r.out.ResultSet.ResultSetMetadata.ColumnInfo[index]
-	if colInfo.Type != nil {
-		return *colInfo.Type
-	}
-	return ""
+	return &r, nil
 }
 
 func (r *rows) Next(dest []driver.Value) error {
 	if r.done {
 		return io.EOF
 	}
-	if r.out == nil || r.out.ResultSet == nil {
-		return io.EOF
+	if err := r.ctx.Err(); err != nil {
+		return err
 	}
-	// If nothing left to iterate...
-	if len(r.out.ResultSet.Rows) == 0 {
-		// And if nothing more to paginate...
-		if r.out.NextToken == nil || *r.out.NextToken == "" {
+	for {
+		if r.out == nil || r.out.ResultSet == nil {
+			return io.EOF
+		}
+		if len(r.page) > 0 {
+			break
+		}
+		// If nothing left to iterate and nothing more to paginate...
+		if !r.hasNextPage() {
 			return io.EOF
 		}
 
@@ -79,7 +109,6 @@ func (r *rows) Next(dest []driver.Value) error {
 		if err != nil {
 			return err
 		}
-
 		if !cont {
 			return io.EOF
 		}
@@ -88,8 +117,7 @@ func (r *rows) Next(dest []driver.Value) error {
 	if currentRow == nil {
 		return io.EOF
 	}
-	columns := r.out.ResultSet.ResultSetMetadata.ColumnInfo
-	if err := convertRow(columns, currentRow.Data, dest); err != nil {
+	if err := convertRow(r.columns, currentRow.Data, dest, r.loc); err != nil {
 		return err
 	}
 	return nil
@@ -97,44 +125,101 @@ func (r *rows) Next(dest []driver.Value) error {
 
 func (r *rows) fetchNextPage(token *string) (bool, error) {
 	// if there are rows left in the current page, return true, else fetch next page
-	if r.out != nil && r.out.ResultSet != nil && len(r.out.ResultSet.Rows) > 0 {
+	if len(r.page) > 0 {
 		return true, nil
 	}
 	var err error
-	r.out, err = r.athena.GetQueryResults(&athena.GetQueryResultsInput{
-		QueryExecutionId: aws.String(r.queryID),
-		NextToken:        token,
-		MaxResults:       aws.Int64(maxResultCnt),
-	})
+	if r.prefetch != nil && token != nil {
+		r.out, err = r.prefetch.next(r.ctx)
+	} else {
+		r.out, err = r.getQueryResults(r.ctx, token)
+	}
 	if err != nil {
+		if ctxErr := r.ctx.Err(); ctxErr != nil {
+			return false, ctxErr
+		}
 		return false, err
 	}
-
-	//  If there are no rows in the result set, return false
-	if r.out == nil || r.out.ResultSet == nil || len(r.out.ResultSet.Rows) == 0 {
+	if r.out == nil || r.out.ResultSet == nil {
 		return false, nil
 	}
+	r.page = r.out.ResultSet.Rows
+
+	firstPage := token == nil
+	if firstPage {
+		r.setColumns()
+	}
+	if r.splitUtility {
+		r.page = splitUtilityRows(r.page, len(r.columns))
+	}
+
+	// If there are no rows in the result set, continue only if more pages
+	// follow
+	if len(r.page) == 0 {
+		return r.hasNextPage(), nil
+	}
 	// First row of the first page contains header if the query is not DDL.
 	// These are also available in *athena.Row.ResultSetMetadata.
-	if r.skipHeaderRow {
-		r.out.ResultSet.Rows = r.out.ResultSet.Rows[1:]
+	if firstPage && r.skipHeaderRow {
+		r.page = r.page[1:]
 	}
-	return true, nil
+	return len(r.page) > 0 || r.hasNextPage(), nil
 }
 
-func (r *rows) popRowInResultSet() *athena.Row {
-	if r.out == nil {
-		return nil
+// getQueryResults fetches the page at token, retrying transient errors so
+// iteration continues where it stopped.
+func (r *rows) getQueryResults(ctx context.Context, token *string) (*athena.GetQueryResultsOutput, error) {
+	var out *athena.GetQueryResultsOutput
+	err := r.retry.do(ctx, "GetQueryResults", r.queryID, func() error {
+		var err error
+		out, err = r.athena.GetQueryResultsWithContext(ctx, &athena.GetQueryResultsInput{
+			QueryExecutionId: aws.String(r.queryID),
+			NextToken:        token,
+			MaxResults:       aws.Int64(r.pageSize),
+		})
+		return err
+	})
+	return out, err
+}
+
+// setColumns takes the column metadata from the first page. Utility statements
+// returned as a single column get their known columns instead.
+func (r *rows) setColumns() {
+	if r.out.ResultSet.ResultSetMetadata != nil {
+		r.columns = r.out.ResultSet.ResultSetMetadata.ColumnInfo
+	}
+	if len(r.utilityColumns) > 0 && len(r.columns) <= 1 {
+		r.columns = utilityColumnInfo(r.utilityColumns)
+		r.splitUtility = true
 	}
-	if len(r.out.ResultSet.Rows) == 0 {
+}
+
+func (r *rows) hasNextPage() bool {
+	return r.out.NextToken != nil && *r.out.NextToken != ""
+}
+
+func (r *rows) popRowInResultSet() *athena.Row {
+	if len(r.page) == 0 {
 		return nil
 	}
-	row := r.out.ResultSet.Rows[0]
-	r.out.ResultSet.Rows = r.out.ResultSet.Rows[1:]
+	row := r.page[0]
+	r.page = r.page[1:]
 	return row
 }
 
 func (r *rows) Close() error {
 	r.done = true
+	r.cancel()
+	if r.prefetch != nil {
+		r.prefetch.stop()
+	}
 	return nil
 }
+
+var (
+	_ driver.RowsColumnTypeDatabaseTypeName = (*rows)(nil)
+	_ driver.RowsColumnTypeScanType         = (*rows)(nil)
+	_ driver.RowsColumnTypeNullable         = (*rows)(nil)
+	_ driver.RowsColumnTypeLength           = (*rows)(nil)
+	_ driver.RowsColumnTypePrecisionScale   = (*rows)(nil)
+)
@@ -1,15 +1,21 @@
 package athena
 
 import (
+	"context"
+	"database/sql"
 	"database/sql/driver"
 	"errors"
 	"io"
 	"math/rand"
+	"reflect"
 	"testing"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/athena"
 	"github.com/aws/aws-sdk-go/service/athena/athenaiface"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 var dummyError = errors.New("dummy error")
@@ -19,7 +25,9 @@ type genQueryResultsOutputByToken func(token string) (*athena.GetQueryResultsOut
 var queryToResultsGenMap = map[string]genQueryResultsOutputByToken{
 	"select":         dummySelectQueryResponse,
 	"show":           dummyShowResponse,
+	"describe":       dummyDescribeResponse,
 	"iteration_fail": dummyFailedIterationResponse,
+	"empty_pages":    dummyEmptyPagesResponse,
 }
 
 func genColumnInfo(column string) *athena.ColumnInfo {
@@ -138,6 +146,30 @@ func dummyShowResponse(_ string) (*athena.GetQueryResultsOutput, error) {
 	}, nil
 }
 
+func dummyDescribeResponse(_ string) (*athena.GetQueryResultsOutput, error) {
+	columns := []*athena.ColumnInfo{
+		genColumnInfo(""),
+	}
+	lines := []string{
+		"id                  \tbigint              \tuser id             ",
+		"name                \tstring              \t                    ",
+		"                    \t                    \t                    ",
+		"dt                  \tstring              \t                    ",
+	}
+	rows := make([]*athena.Row, 0, len(lines))
+	for i := range lines {
+		rows = append(rows, &athena.Row{Data: []*athena.Datum{{VarCharValue: &lines[i]}}})
+	}
+	return &athena.GetQueryResultsOutput{
+		ResultSet: &athena.ResultSet{
+			ResultSetMetadata: &athena.ResultSetMetadata{
+				ColumnInfo: columns,
+			},
+			Rows: rows,
+		},
+	}, nil
+}
+
 func dummyFailedIterationResponse(token string) (*athena.GetQueryResultsOutput, error) {
 	switch token {
 	case "":
@@ -166,11 +198,53 @@ func dummyFailedIterationResponse(token string) (*athena.GetQueryResultsOutput,
 	}
 }
 
+// dummyEmptyPagesResponse returns a page without rows between two pages with
+// rows, and a last page without result set.
+func dummyEmptyPagesResponse(token string) (*athena.GetQueryResultsOutput, error) {
+	columns := []*athena.ColumnInfo{
+		genColumnInfo("first_name"),
+		genColumnInfo("last_name"),
+	}
+	switch token {
+	case "":
+		return &athena.GetQueryResultsOutput{
+			NextToken: aws.String("page_1"),
+			ResultSet: &athena.ResultSet{
+				ResultSetMetadata: &athena.ResultSetMetadata{
+					ColumnInfo: columns,
+				},
+				Rows: []*athena.Row{
+					genRow(true, columns),
+					genRow(false, columns),
+				},
+			},
+		}, nil
+	case "page_1":
+		return &athena.GetQueryResultsOutput{
+			NextToken: aws.String("page_2"),
+			ResultSet: &athena.ResultSet{},
+		}, nil
+	case "page_2":
+		return &athena.GetQueryResultsOutput{
+			NextToken: aws.String("page_3"),
+			ResultSet: &athena.ResultSet{
+				Rows: []*athena.Row{
+					genRow(false, columns),
+				},
+			},
+		}, nil
+	case "page_3":
+		return &athena.GetQueryResultsOutput{}, nil
+	default:
+		return nil, dummyError
+	}
+}
+
 type mockAthenaClient struct {
 	athenaiface.AthenaAPI
 }
 
-func (m *mockAthenaClient) GetQueryResults(query *athena.GetQueryResultsInput) (*athena.GetQueryResultsOutput, error) {
+func (m *mockAthenaClient) GetQueryResultsWithContext(_ aws.Context, query *athena.GetQueryResultsInput, _ ...request.Option) (*athena.GetQueryResultsOutput, error) {
 	var nextToken = ""
 	if query.NextToken != nil {
 		nextToken = *query.NextToken
@@ -204,6 +278,13 @@ func TestRows_Next(t *testing.T) {
 			expectedResultsSize: 9,
 			expectedError:       nil,
 		},
+		{
+			desc:                "select query, empty page and page without result set, 2 rows, no error",
+			queryID:             "empty_pages",
+			skipHeader:          true,
+			expectedResultsSize: 2,
+			expectedError:       nil,
+		},
 		{
 			desc:          "failed during calling next",
 			queryID:       "iteration_fail",
@@ -212,26 +293,209 @@ func TestRows_Next(t *testing.T) {
 		},
 	}
 	for _, test := range tests {
-		r, _ := newRows(rowsConfig{
-			Athena:     new(mockAthenaClient),
-			QueryID:    test.queryID,
-			SkipHeader: test.skipHeader,
-		})
+		for _, prefetchDepth := range []int{0, 1, 3} {
+			r, _ := newRows(context.Background(), rowsConfig{
+				Athena:        new(mockAthenaClient),
+				QueryID:       test.queryID,
+				SkipHeader:    test.skipHeader,
+				PrefetchDepth: prefetchDepth,
+			})
 
-		var firstName, lastName string
-		cnt := 0
-		for {
-			err := r.Next(castToValue(&firstName, &lastName))
-			if err != nil {
-				if err != io.EOF {
-					assert.Equal(t, test.expectedError, err)
+			var firstName, lastName string
+			cnt := 0
+			for {
+				err := r.Next(castToValue(&firstName, &lastName))
+				if err != nil {
+					if err != io.EOF {
+						assert.Equal(t, test.expectedError, err)
+					}
+					break
 				}
-				break
+				cnt++
+			}
+			if test.expectedError == nil {
+				assert.Equal(t, test.expectedResultsSize, cnt, test.desc)
 			}
-			cnt++
+			assert.NoError(t, r.Close())
 		}
-		if test.expectedError == nil {
-			assert.Equal(t, test.expectedResultsSize, cnt)
+	}
+}
+
+type blockingAthenaClient struct {
+	mockAthenaClient
+	fetched chan string
+}
+
+func (m *blockingAthenaClient) GetQueryResultsWithContext(ctx aws.Context, query *athena.GetQueryResultsInput, _ ...request.Option) (*athena.GetQueryResultsOutput, error) {
+	if query.NextToken == nil {
+		return m.mockAthenaClient.GetQueryResultsWithContext(ctx, query)
+	}
+	m.fetched <- *query.NextToken
+	return m.mockAthenaClient.GetQueryResultsWithContext(ctx, query)
+}
+
+func TestRows_PrefetchStopsOnClose(t *testing.T) {
+	client := &blockingAthenaClient{fetched: make(chan string)}
+	r, err := newRows(context.Background(), rowsConfig{
+		Athena:        client,
+		QueryID:       "select",
+		SkipHeader:    true,
+		PrefetchDepth: 1,
+	})
+	assert.NoError(t, err)
+
+	// the second page is requested before the first one is consumed
+	assert.Equal(t, "page_1", <-client.fetched)
+	assert.NoError(t, r.Close())
+	assert.Equal(t, io.EOF, r.Next(make([]driver.Value, 2)))
+}
+
+func TestRows_NextReturnsContextError(t *testing.T) {
+	for _, prefetchDepth := range []int{0, 1} {
+		ctx, cancel := context.WithCancel(context.Background())
+		r, err := newRows(ctx, rowsConfig{
+			Athena:        new(mockAthenaClient),
+			QueryID:       "select",
+			SkipHeader:    true,
+			PrefetchDepth: prefetchDepth,
+		})
+		require.NoError(t, err)
+
+		dest := make([]driver.Value, 2)
+		require.NoError(t, r.Next(dest))
+		cancel()
+		assert.Equal(t, context.Canceled, r.Next(dest))
+		assert.NoError(t, r.Close())
+	}
+}
+
+func TestRows_UtilityStatement(t *testing.T) {
+	exec := &athena.QueryExecution{
+		StatementType:    aws.String(athena.StatementTypeDdl),
+		SubstatementType: aws.String("DESCRIBE_TABLE"),
+	}
+	utilityColumns, ok := utilityColumnsOf(exec)
+	assert.True(t, ok)
+	assert.False(t, hasHeaderRow(exec))
+
+	r, err := newRows(context.Background(), rowsConfig{
+		Athena:         new(mockAthenaClient),
+		QueryID:        "describe",
+		SkipHeader:     hasHeaderRow(exec),
+		UtilityColumns: utilityColumns,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"col_name", "data_type", "comment"}, r.Columns())
+
+	var got [][]driver.Value
+	for {
+		dest := make([]driver.Value, 3)
+		if err := r.Next(dest); err != nil {
+			assert.Equal(t, io.EOF, err)
+			break
 		}
+		got = append(got, dest)
+	}
+	assert.Equal(t, [][]driver.Value{
+		{"id", "bigint", "user id"},
+		{"name", "string", ""},
+		{"dt", "string", ""},
+	}, got)
+}
+
+func TestHasHeaderRow(t *testing.T) {
+	assert.True(t, hasHeaderRow(nil))
+	assert.True(t, hasHeaderRow(&athena.QueryExecution{
+		StatementType:    aws.String(athena.StatementTypeDml),
+		SubstatementType: aws.String("SELECT"),
+	}))
+	assert.False(t, hasHeaderRow(&athena.QueryExecution{
+		StatementType:    aws.String(athena.StatementTypeUtility),
+		SubstatementType: aws.String("SHOW_TABLES"),
+	}))
+	assert.False(t, hasHeaderRow(&athena.QueryExecution{
+		StatementType:    aws.String(athena.StatementTypeDml),
+		SubstatementType: aws.String("EXPLAIN"),
+	}))
+	assert.False(t, hasHeaderRow(&athena.QueryExecution{
+		StatementType:    aws.String(athena.StatementTypeDdl),
+		SubstatementType: aws.String("CREATE_TABLE"),
+	}))
+}
+
+func TestRows_ColumnTypes(t *testing.T) {
+	r := &rows{resultColumns: resultColumns{columns: []*athena.ColumnInfo{
+		{Name: aws.String("amount"), Type: aws.String("decimal"), Precision: aws.Int64(38), Scale: aws.Int64(9), Nullable: aws.String("NOT_NULL")},
+		{Name: aws.String("name"), Type: aws.String("varchar"), Precision: aws.Int64(255), Scale: aws.Int64(0), Nullable: aws.String("NULLABLE")},
+		{Name: aws.String("id"), Type: aws.String("bigint"), Precision: aws.Int64(19), Scale: aws.Int64(0), Nullable: aws.String("UNKNOWN")},
+	}}}
+
+	precision, scale, ok := r.ColumnTypePrecisionScale(0)
+	assert.True(t, ok)
+	assert.Equal(t, int64(38), precision)
+	assert.Equal(t, int64(9), scale)
+	_, _, ok = r.ColumnTypePrecisionScale(1)
+	assert.False(t, ok)
+
+	length, ok := r.ColumnTypeLength(1)
+	assert.True(t, ok)
+	assert.Equal(t, int64(255), length)
+	_, ok = r.ColumnTypeLength(2)
+	assert.False(t, ok)
+
+	nullable, ok := r.ColumnTypeNullable(0)
+	assert.True(t, ok)
+	assert.False(t, nullable)
+	nullable, ok = r.ColumnTypeNullable(1)
+	assert.True(t, ok)
+	assert.True(t, nullable)
+	_, ok = r.ColumnTypeNullable(2)
+	assert.False(t, ok)
+
+	assert.Equal(t, reflect.TypeOf(Decimal{}), r.ColumnTypeScanType(0))
+	assert.Equal(t, reflect.TypeOf(sql.NullString{}), r.ColumnTypeScanType(1))
+	assert.Equal(t, reflect.TypeOf(sql.NullInt64{}), r.ColumnTypeScanType(2))
+}
+
+type pageSizeAthenaClient struct {
+	mockAthenaClient
+	maxResults []int64
+}
+
+func (m *pageSizeAthenaClient) GetQueryResultsWithContext(ctx aws.Context, query *athena.GetQueryResultsInput, _ ...request.Option) (*athena.GetQueryResultsOutput, error) {
+	m.maxResults = append(m.maxResults, *query.MaxResults)
+	return m.mockAthenaClient.GetQueryResultsWithContext(ctx, query)
+}
+
+func TestRows_PageSize(t *testing.T) {
+	client := &pageSizeAthenaClient{}
+	r, err := newRows(context.Background(), rowsConfig{
+		Athena:     client,
+		QueryID:    "select",
+		SkipHeader: true,
+		PageSize:   5,
+	})
+	assert.NoError(t, err)
+	for r.Next(make([]driver.Value, 2)) == nil {
 	}
+	assert.Equal(t, []int64{5, 5}, client.maxResults)
+
+	client.maxResults = nil
+	_, err = newRows(context.Background(), rowsConfig{Athena: client, QueryID: "show"})
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{maxResultCnt}, client.maxResults)
+}
+
+func TestConn_PageSizeValidation(t *testing.T) {
+	c := &conn{athena: new(mockAthenaClient)}
+	for _, size := range []int64{0, 1001} {
+		_, err := c.QueryContext(WithPageSize(context.Background(), size), "SELECT 1", nil)
+		assert.Error(t, err, size)
+	}
+
+	_, err := configFromConnectionString("region=us-east-1&aws_access_key=a&aws_access_key_secret=b&page_size=2000")
+	assert.Error(t, err)
+	cfg, err := configFromConnectionString("region=us-east-1&aws_access_key=a&aws_access_key_secret=b&page_size=50")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(50), cfg.PageSize)
 }
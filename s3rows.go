@@ -0,0 +1,376 @@
+package athena
+
+import (
+	"bufio"
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/aws/aws-sdk-go/service/athena/athenaiface"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// ResultMode selects how query results are downloaded.
+type ResultMode string
+
+const (
+	// ResultModeAPI pages through results with GetQueryResults.
+	ResultModeAPI ResultMode = "api"
+	// ResultModeS3 streams the CSV file Athena writes to the output location,
+	// which is much faster for large results.
+	ResultModeS3 ResultMode = "s3"
+)
+
+// s3Rows streams query results from the `<queryID>.csv` file Athena writes to
+// the output location.
+type s3Rows struct {
+	ctx    context.Context
+	body   io.ReadCloser
+	reader *csvReader
+	loc    *time.Location
+	resultColumns
+}
+
+type s3RowsConfig struct {
+	Athena athenaiface.AthenaAPI
+	S3     s3iface.S3API
+	// QueryID is used to fetch the column metadata of the result.
+	QueryID string
+	// OutputLocation is the S3 URL of the result CSV file.
+	OutputLocation string
+	Location       *time.Location
+}
+
+func newS3Rows(ctx context.Context, cfg s3RowsConfig) (*s3Rows, error) {
+	bucket, key, err := parseS3URL(cfg.OutputLocation)
+	if err != nil {
+		return nil, err
+	}
+	columns, err := resultMetadata(ctx, cfg, bucket, key)
+	if err != nil {
+		return nil, err
+	}
+
+	obj, err := cfg.S3.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	r := &s3Rows{
+		ctx:           ctx,
+		body:          obj.Body,
+		reader:        newCSVReader(obj.Body),
+		loc:           cfg.Location,
+		resultColumns: resultColumns{columns: columns},
+	}
+	// The first line of the file holds the column names.
+	if _, err := r.reader.read(); err != nil && err != io.EOF {
+		r.body.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// resultMetadata returns the columns of the result file at bucket and key,
+// read from the `.csv.metadata` file Athena writes next to it. If that file
+// is missing or can't be decoded, they are taken from a single-row
+// GetQueryResults call instead.
+func resultMetadata(ctx context.Context, cfg s3RowsConfig, bucket, key string) ([]*athena.ColumnInfo, error) {
+	obj, err := cfg.S3.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key + ".metadata"),
+	})
+	if err == nil {
+		b, err := io.ReadAll(obj.Body)
+		obj.Body.Close()
+		if err == nil {
+			if columns, ok := decodeResultMetadata(b); ok {
+				return columns, nil
+			}
+		}
+	}
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return nil, ctxErr
+	}
+
+	out, err := cfg.Athena.GetQueryResultsWithContext(ctx, &athena.GetQueryResultsInput{
+		QueryExecutionId: aws.String(cfg.QueryID),
+		MaxResults:       aws.Int64(1),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if out.ResultSet == nil || out.ResultSet.ResultSetMetadata == nil {
+		return nil, fmt.Errorf("query %s has no result metadata", cfg.QueryID)
+	}
+	return out.ResultSet.ResultSetMetadata.ColumnInfo, nil
+}
+
+// decodeResultMetadata decodes a `.csv.metadata` file. It is a protobuf
+// message holding one field 1 per column, whose fields are those of
+// athena.ColumnInfo in API order: catalog (1), schema (2), table (3), name
+// (4), label (5), type (6), precision (7), scale (8), nullability (9) as the
+// JDBC constants and case sensitivity (10). The format is undocumented, so
+// anything else is reported as undecodable.
+func decodeResultMetadata(b []byte) ([]*athena.ColumnInfo, bool) {
+	var columns []*athena.ColumnInfo
+	err := decodeProtoFields(b, func(num protowire.Number, typ protowire.Type, val []byte, n uint64) error {
+		if num != 1 || typ != protowire.BytesType {
+			return nil
+		}
+		column, err := decodeColumnInfo(val)
+		if err != nil {
+			return err
+		}
+		columns = append(columns, column)
+		return nil
+	})
+	return columns, err == nil && len(columns) > 0
+}
+
+// jdbcNullability maps the JDBC nullability constants to the Nullable values
+// of athena.ColumnInfo.
+var jdbcNullability = map[uint64]string{
+	0: athena.ColumnNullableNotNull,
+	1: athena.ColumnNullableNullable,
+	2: athena.ColumnNullableUnknown,
+}
+
+func decodeColumnInfo(b []byte) (*athena.ColumnInfo, error) {
+	column := &athena.ColumnInfo{}
+	err := decodeProtoFields(b, func(num protowire.Number, typ protowire.Type, val []byte, n uint64) error {
+		str := func(dst **string) error {
+			if typ != protowire.BytesType {
+				return fmt.Errorf("column metadata field %d is not a string", num)
+			}
+			*dst = aws.String(string(val))
+			return nil
+		}
+		if num >= 7 && typ != protowire.VarintType {
+			return fmt.Errorf("column metadata field %d is not a number", num)
+		}
+		switch num {
+		case 1:
+			return str(&column.CatalogName)
+		case 2:
+			return str(&column.SchemaName)
+		case 3:
+			return str(&column.TableName)
+		case 4:
+			return str(&column.Name)
+		case 5:
+			return str(&column.Label)
+		case 6:
+			return str(&column.Type)
+		case 7:
+			column.Precision = aws.Int64(int64(n))
+		case 8:
+			column.Scale = aws.Int64(int64(n))
+		case 9:
+			nullable, ok := jdbcNullability[n]
+			if !ok {
+				return fmt.Errorf("unknown column nullability %d", n)
+			}
+			column.Nullable = aws.String(nullable)
+		case 10:
+			column.CaseSensitive = aws.Bool(n != 0)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if column.Name == nil || column.Type == nil {
+		return nil, errors.New("column metadata without name or type")
+	}
+	return column, nil
+}
+
+// decodeProtoFields calls fn with the number, wire type and value of each
+// field of the protobuf message b: the bytes of length-delimited fields and
+// the number of varint fields.
+func decodeProtoFields(b []byte, fn func(num protowire.Number, typ protowire.Type, val []byte, n uint64) error) error {
+	for len(b) > 0 {
+		num, typ, l := protowire.ConsumeTag(b)
+		if l < 0 {
+			return protowire.ParseError(l)
+		}
+		b = b[l:]
+
+		var (
+			val []byte
+			n   uint64
+		)
+		switch typ {
+		case protowire.BytesType:
+			val, l = protowire.ConsumeBytes(b)
+		case protowire.VarintType:
+			n, l = protowire.ConsumeVarint(b)
+		default:
+			l = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if l < 0 {
+			return protowire.ParseError(l)
+		}
+		b = b[l:]
+		if err := fn(num, typ, val, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *s3Rows) Next(dest []driver.Value) error {
+	if err := r.ctx.Err(); err != nil {
+		return err
+	}
+	record, err := r.reader.read()
+	if err != nil {
+		if ctxErr := r.ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		return err
+	}
+	if len(record) != len(r.columns) {
+		return fmt.Errorf("result has %d columns but got a record with %d fields", len(r.columns), len(record))
+	}
+	for i, field := range record {
+		// Athena leaves NULLs empty and unquoted, which can't be told apart
+		// from an empty string of a column known not to be nullable.
+		if field == nil && aws.StringValue(r.columns[i].Nullable) == athena.ColumnNullableNotNull {
+			field = aws.String("")
+		}
+		dest[i], err = convertValue(*r.columns[i].Type, field, r.loc)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *s3Rows) Close() error {
+	return r.body.Close()
+}
+
+var (
+	_ driver.RowsColumnTypeDatabaseTypeName = (*s3Rows)(nil)
+	_ driver.RowsColumnTypeScanType         = (*s3Rows)(nil)
+	_ driver.RowsColumnTypeNullable         = (*s3Rows)(nil)
+	_ driver.RowsColumnTypeLength           = (*s3Rows)(nil)
+	_ driver.RowsColumnTypePrecisionScale   = (*s3Rows)(nil)
+)
+
+// parseS3URL splits an "s3://bucket/key" URL into its bucket and key.
+func parseS3URL(s3URL string) (bucket, key string, err error) {
+	u, err := url.Parse(s3URL)
+	if err != nil {
+		return "", "", err
+	}
+	if u.Scheme != "s3" || u.Host == "" {
+		return "", "", fmt.Errorf("invalid S3 URL: %s", s3URL)
+	}
+	return u.Host, strings.TrimPrefix(u.Path, "/"), nil
+}
+
+// csvReader reads RFC 4180 records as written by Athena, which quotes every
+// non-NULL value and leaves NULLs as empty unquoted fields. encoding/csv can't
+// be used as it doesn't tell quoted and unquoted fields apart.
+type csvReader struct {
+	r *bufio.Reader
+}
+
+func newCSVReader(r io.Reader) *csvReader {
+	return &csvReader{r: bufio.NewReaderSize(r, 64*1024)}
+}
+
+const (
+	csvFieldStart = iota
+	csvUnquoted
+	csvQuoted
+	csvQuoteInQuoted
+)
+
+// read returns the next record, with nil for NULL fields.
+func (c *csvReader) read() ([]*string, error) {
+	var (
+		record []*string
+		field  strings.Builder
+		quoted bool
+		state  = csvFieldStart
+	)
+	endField := func() {
+		if quoted || field.Len() > 0 {
+			val := field.String()
+			record = append(record, &val)
+		} else {
+			record = append(record, nil)
+		}
+		field.Reset()
+		quoted = false
+	}
+
+	for {
+		b, err := c.r.ReadByte()
+		if err == io.EOF {
+			if state == csvFieldStart && len(record) == 0 {
+				return nil, io.EOF
+			}
+			if state == csvQuoted {
+				return nil, errors.New("unexpected end of file in quoted CSV field")
+			}
+			endField()
+			return record, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch state {
+		case csvQuoted:
+			if b == '"' {
+				state = csvQuoteInQuoted
+			} else {
+				field.WriteByte(b)
+			}
+			continue
+		case csvQuoteInQuoted:
+			if b == '"' {
+				// escaped quote
+				field.WriteByte(b)
+				state = csvQuoted
+				continue
+			}
+		case csvFieldStart:
+			if b == '"' {
+				quoted = true
+				state = csvQuoted
+				continue
+			}
+		}
+
+		switch b {
+		case ',':
+			endField()
+			state = csvFieldStart
+		case '\n':
+			endField()
+			return record, nil
+		case '\r':
+		default:
+			field.WriteByte(b)
+			state = csvUnquoted
+		}
+	}
+}
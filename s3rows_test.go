@@ -0,0 +1,235 @@
+package athena
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/aws/aws-sdk-go/service/athena/athenaiface"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+type fakeS3 struct {
+	s3iface.S3API
+	objects map[string]string
+}
+
+func (f *fakeS3) GetObjectWithContext(_ aws.Context, input *s3.GetObjectInput, _ ...request.Option) (*s3.GetObjectOutput, error) {
+	body, ok := f.objects[*input.Bucket+"/"+*input.Key]
+	if !ok {
+		return nil, awserr.New(s3.ErrCodeNoSuchKey, "no such key", nil)
+	}
+	return &s3.GetObjectOutput{Body: io.NopCloser(strings.NewReader(body))}, nil
+}
+
+func (f *fakeS3) ListObjectsV2PagesWithContext(ctx aws.Context, input *s3.ListObjectsV2Input, fn func(*s3.ListObjectsV2Output, bool) bool, _ ...request.Option) error {
+	if err := ctx.Err(); err != nil {
+		return awserr.New(request.CanceledErrorCode, "request context canceled", err)
+	}
+	out := &s3.ListObjectsV2Output{}
+	for path := range f.objects {
+		bucket, key, _ := strings.Cut(path, "/")
+		if bucket == *input.Bucket && strings.HasPrefix(key, *input.Prefix) {
+			out.Contents = append(out.Contents, &s3.Object{Key: aws.String(key)})
+		}
+	}
+	fn(out, true)
+	return nil
+}
+
+func (f *fakeS3) DeleteObjectsWithContext(ctx aws.Context, input *s3.DeleteObjectsInput, _ ...request.Option) (*s3.DeleteObjectsOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, awserr.New(request.CanceledErrorCode, "request context canceled", err)
+	}
+	for _, obj := range input.Delete.Objects {
+		delete(f.objects, *input.Bucket+"/"+*obj.Key)
+	}
+	return &s3.DeleteObjectsOutput{}, nil
+}
+
+type fakeS3ModeAthena struct {
+	athenaiface.AthenaAPI
+	columns []*athena.ColumnInfo
+}
+
+func (f *fakeS3ModeAthena) StartQueryExecution(*athena.StartQueryExecutionInput) (*athena.StartQueryExecutionOutput, error) {
+	return &athena.StartQueryExecutionOutput{QueryExecutionId: aws.String("query-1")}, nil
+}
+
+func (f *fakeS3ModeAthena) GetQueryExecutionWithContext(aws.Context, *athena.GetQueryExecutionInput, ...request.Option) (*athena.GetQueryExecutionOutput, error) {
+	return &athena.GetQueryExecutionOutput{QueryExecution: &athena.QueryExecution{
+		QueryExecutionId: aws.String("query-1"),
+		StatementType:    aws.String(athena.StatementTypeDml),
+		SubstatementType: aws.String("SELECT"),
+		Status:           &athena.QueryExecutionStatus{State: aws.String(athena.QueryExecutionStateSucceeded)},
+		ResultConfiguration: &athena.ResultConfiguration{
+			OutputLocation: aws.String("s3://results/output/query-1.csv"),
+		},
+	}}, nil
+}
+
+func (f *fakeS3ModeAthena) GetQueryResultsWithContext(aws.Context, *athena.GetQueryResultsInput, ...request.Option) (*athena.GetQueryResultsOutput, error) {
+	return &athena.GetQueryResultsOutput{ResultSet: &athena.ResultSet{
+		ResultSetMetadata: &athena.ResultSetMetadata{ColumnInfo: f.columns},
+	}}, nil
+}
+
+func TestCSVReader(t *testing.T) {
+	in := "\"id\",\"note\",\"score\"\r\n" +
+		"\"1\",\"multi\nline, with \"\"quotes\"\"\",\"1.5\"\n" +
+		"\"2\",\"\",\n" +
+		",\"x\",\"3\""
+	r := newCSVReader(strings.NewReader(in))
+
+	var records [][]*string
+	for {
+		record, err := r.read()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		records = append(records, record)
+	}
+
+	assert.Equal(t, [][]*string{
+		{aws.String("id"), aws.String("note"), aws.String("score")},
+		{aws.String("1"), aws.String("multi\nline, with \"quotes\""), aws.String("1.5")},
+		{aws.String("2"), aws.String(""), nil},
+		{nil, aws.String("x"), aws.String("3")},
+	}, records)
+
+	_, err := newCSVReader(strings.NewReader("\"unterminated")).read()
+	assert.Error(t, err)
+}
+
+func TestConn_ResultModeS3(t *testing.T) {
+	c := &conn{
+		athena: &fakeS3ModeAthena{columns: []*athena.ColumnInfo{
+			{Name: aws.String("id"), Type: aws.String("integer")},
+			{Name: aws.String("name"), Type: aws.String("varchar")},
+			{Name: aws.String("amount"), Type: aws.String("decimal"), Precision: aws.Int64(10), Scale: aws.Int64(2)},
+		}},
+		s3: &fakeS3{objects: map[string]string{
+			"results/output/query-1.csv": "\"id\",\"name\",\"amount\"\n\"1\",\"a\nb\",\"10.50\"\n\"2\",,\n",
+		}},
+		resultMode:    ResultModeS3,
+		pollFrequency: defaultPollFrequency,
+	}
+
+	driverRows, err := c.QueryContext(context.Background(), "SELECT id, name, amount FROM t", nil)
+	require.NoError(t, err)
+	r, ok := driverRows.(*s3Rows)
+	require.True(t, ok)
+	assert.Equal(t, []string{"id", "name", "amount"}, r.Columns())
+
+	var got [][]driver.Value
+	for {
+		dest := make([]driver.Value, 3)
+		if err := r.Next(dest); err != nil {
+			require.Equal(t, io.EOF, err)
+			break
+		}
+		got = append(got, dest)
+	}
+	assert.Equal(t, [][]driver.Value{
+		{int64(1), "a\nb", "10.50"},
+		{int64(2), nil, nil},
+	}, got)
+	assert.NoError(t, r.Close())
+}
+
+func TestConn_ResultModeS3_NoClient(t *testing.T) {
+	fake := NewFakeAthena()
+	fake.Register("SELECT 1", FakeResult{Columns: []FakeColumn{{Name: "one", Type: "integer"}}, Rows: [][]interface{}{{1}}})
+	connector, err := NewConnector(Config{Athena: fake, ResultMode: ResultModeS3})
+	require.NoError(t, err)
+	db := sql.OpenDB(connector)
+	defer db.Close()
+
+	_, err = db.Query("SELECT 1")
+	assert.EqualError(t, err, "result mode s3 requires an S3 client")
+	assert.Empty(t, fake.Queries())
+}
+
+// encodeResultMetadata encodes columns the way decodeResultMetadata reads
+// them.
+func encodeResultMetadata(columns []*athena.ColumnInfo) string {
+	var b []byte
+	for _, column := range columns {
+		var c []byte
+		c = protowire.AppendTag(c, 4, protowire.BytesType)
+		c = protowire.AppendString(c, *column.Name)
+		c = protowire.AppendTag(c, 6, protowire.BytesType)
+		c = protowire.AppendString(c, *column.Type)
+		c = protowire.AppendTag(c, 9, protowire.VarintType)
+		if aws.StringValue(column.Nullable) == athena.ColumnNullableNotNull {
+			c = protowire.AppendVarint(c, 0)
+		} else {
+			c = protowire.AppendVarint(c, 1)
+		}
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendBytes(b, c)
+	}
+	return string(b)
+}
+
+func TestConn_ResultModeS3_Metadata(t *testing.T) {
+	c := &conn{
+		// the columns come from the metadata file only
+		athena: &fakeS3ModeAthena{},
+		s3: &fakeS3{objects: map[string]string{
+			"results/output/query-1.csv": "\"id\",\"name\",\"note\"\n\"1\",,\n",
+			"results/output/query-1.csv.metadata": encodeResultMetadata([]*athena.ColumnInfo{
+				{Name: aws.String("id"), Type: aws.String("integer")},
+				{Name: aws.String("name"), Type: aws.String("varchar"), Nullable: aws.String(athena.ColumnNullableNotNull)},
+				{Name: aws.String("note"), Type: aws.String("varchar"), Nullable: aws.String(athena.ColumnNullableNullable)},
+			}),
+		}},
+		resultMode:    ResultModeS3,
+		pollFrequency: defaultPollFrequency,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	driverRows, err := c.QueryContext(ctx, "SELECT id, name, note FROM t", nil)
+	require.NoError(t, err)
+	r := driverRows.(*s3Rows)
+	assert.Equal(t, []string{"id", "name", "note"}, r.Columns())
+	assert.Equal(t, "varchar", r.ColumnTypeDatabaseTypeName(1))
+
+	dest := make([]driver.Value, 3)
+	require.NoError(t, r.Next(dest))
+	assert.Equal(t, []driver.Value{int64(1), "", nil}, dest)
+
+	cancel()
+	assert.Equal(t, context.Canceled, r.Next(dest))
+	assert.NoError(t, r.Close())
+}
+
+func TestDecodeResultMetadata_Invalid(t *testing.T) {
+	for _, b := range []string{"", "\xff", "garbage", encodeResultMetadata(nil)} {
+		_, ok := decodeResultMetadata([]byte(b))
+		assert.False(t, ok, "%q", b)
+	}
+}
+
+func TestParseS3URL(t *testing.T) {
+	bucket, key, err := parseS3URL("s3://results/output/query-1.csv")
+	require.NoError(t, err)
+	assert.Equal(t, "results", bucket)
+	assert.Equal(t, "output/query-1.csv", key)
+
+	_, _, err = parseS3URL("https://results/output")
+	assert.Error(t, err)
+}
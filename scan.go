@@ -0,0 +1,583 @@
+package athena
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+type strictScanKey struct{}
+
+// WithStrictScan returns a context that makes QueryStructs and QueryRowStruct
+// fail when a column, or a field of a ROW value, has no matching struct field
+// instead of ignoring it.
+func WithStrictScan(ctx context.Context) context.Context {
+	return context.WithValue(ctx, strictScanKey{}, true)
+}
+
+func strictScanFromContext(ctx context.Context) bool {
+	strict, _ := ctx.Value(strictScanKey{}).(bool)
+	return strict
+}
+
+// QueryStructs runs query on db and scans every row into a T, which must be a
+// struct type.
+//
+// Columns are matched to exported fields by their `athena:"name"` tag, or
+// else by the snake_case form of the field name, e.g. UserID matches user_id.
+// Fields tagged `athena:"-"` are skipped and the fields of embedded structs
+// are matched as if they were fields of T. ROW columns are scanned into
+// nested struct fields by the same rules, ARRAY columns into slices and MAP
+// columns into maps, whose elements may be rows, arrays and maps again. NULL
+// values require a pointer or sql.Scanner field, e.g. *string or
+// sql.NullString. Timestamps inside ROW, ARRAY and MAP values are read as
+// UTC.
+//
+// As Athena doesn't quote the varchar values inside them, a varchar element
+// containing ", " is read as two elements, see UNLOAD to read such values.
+//
+// Columns without a matching field are ignored, see WithStrictScan.
+func QueryStructs[T any](ctx context.Context, db *sql.DB, query string, args ...interface{}) ([]T, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ret []T
+	err = scanStructs(rows, strictScanFromContext(ctx), func(scan func(dst interface{}) error) error {
+		var v T
+		if err := scan(&v); err != nil {
+			return err
+		}
+		ret = append(ret, v)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// QueryRowStruct runs query on db and scans its first row into a T like
+// QueryStructs. It returns sql.ErrNoRows if the query returns no rows.
+func QueryRowStruct[T any](ctx context.Context, db *sql.DB, query string, args ...interface{}) (T, error) {
+	var ret T
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return ret, err
+	}
+	defer rows.Close()
+
+	found := false
+	err = scanStructs(rows, strictScanFromContext(ctx), func(scan func(dst interface{}) error) error {
+		found = true
+		if err := scan(&ret); err != nil {
+			return err
+		}
+		return errStopScan
+	})
+	if err != nil && err != errStopScan {
+		return ret, err
+	}
+	if !found {
+		return ret, sql.ErrNoRows
+	}
+	return ret, nil
+}
+
+// errStopScan stops scanStructs after the current row.
+var errStopScan = errors.New("stop scan")
+
+// scanStructs calls fn for every row of rows with a function scanning the
+// row into dst, a pointer to a struct.
+func scanStructs(rows *sql.Rows, strict bool, fn func(scan func(dst interface{}) error) error) error {
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+
+	var fields map[string][]int
+	scan := func(dst interface{}) error {
+		v := reflect.ValueOf(dst).Elem()
+		if v.Kind() != reflect.Struct {
+			return fmt.Errorf("cannot scan rows into %s, a struct type is required", v.Type())
+		}
+		if fields == nil {
+			fields = structFields(v.Type())
+		}
+
+		dest := make([]interface{}, len(columns))
+		for i, column := range columns {
+			index, ok := fields[strings.ToLower(column)]
+			if !ok {
+				if strict {
+					return fmt.Errorf("column %s has no matching field in %s", column, v.Type())
+				}
+				dest[i] = new(interface{})
+				continue
+			}
+			field := v.FieldByIndex(index)
+			if isRowField(field.Type()) || isCollectionField(field.Type()) {
+				dest[i] = &rowScanner{dst: field, strict: strict}
+			} else {
+				dest[i] = field.Addr().Interface()
+			}
+		}
+		return rows.Scan(dest...)
+	}
+
+	for rows.Next() {
+		if err := fn(scan); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// fieldCache caches the result of structFields by struct type.
+var fieldCache sync.Map
+
+// structFields returns the index of the fields of the struct type t by their
+// lower-cased column name.
+func structFields(t reflect.Type) map[string][]int {
+	if fields, ok := fieldCache.Load(t); ok {
+		return fields.(map[string][]int)
+	}
+
+	fields := make(map[string][]int)
+	visitStructFields(t, nil, func(name string, _ reflect.StructField, index []int) {
+		// fields of T win over those of embedded structs
+		if _, ok := fields[name]; !ok || len(fields[name]) > len(index) {
+			fields[name] = index
+		}
+	})
+
+	fieldCache.Store(t, fields)
+	return fields
+}
+
+// visitStructFields calls fn with the lower-cased column name and index of
+// the fields of the struct type t in declaration order, walking into embedded
+// structs.
+func visitStructFields(t reflect.Type, index []int, fn func(name string, f reflect.StructField, index []int)) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag, hasTag := f.Tag.Lookup("athena")
+		if tag == "-" {
+			continue
+		}
+		fieldIndex := append(append([]int(nil), index...), i)
+		if f.Anonymous && !hasTag && f.Type.Kind() == reflect.Struct && isRowField(f.Type) {
+			visitStructFields(f.Type, fieldIndex, fn)
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+
+		name := tag
+		if name == "" {
+			name = toSnakeCase(f.Name)
+		}
+		fn(strings.ToLower(name), f, fieldIndex)
+	}
+}
+
+// toSnakeCase converts a Go field name such as UserID to user_id.
+func toSnakeCase(name string) string {
+	runes := []rune(name)
+	var b strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) && i > 0 {
+			prev := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+				b.WriteByte('_')
+			}
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
+
+var (
+	typeTime    = reflect.TypeOf(time.Time{})
+	typeScanner = reflect.TypeOf((*sql.Scanner)(nil)).Elem()
+)
+
+// isRowField reports whether a field of type t is scanned from a ROW value,
+// i.e. is a struct, or a pointer to one, that isn't a time.Time or a
+// sql.Scanner.
+func isRowField(t reflect.Type) bool {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.Struct && t != typeTime && !reflect.PointerTo(t).Implements(typeScanner)
+}
+
+// isCollectionField reports whether a field of type t is scanned from an
+// ARRAY or MAP value, i.e. is a slice other than []byte, or a map, or a
+// pointer to one, that isn't a sql.Scanner.
+func isCollectionField(t reflect.Type) bool {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if reflect.PointerTo(t).Implements(typeScanner) {
+		return false
+	}
+	return (t.Kind() == reflect.Slice && t.Elem().Kind() != reflect.Uint8) || t.Kind() == reflect.Map
+}
+
+// rowScanner scans a ROW, ARRAY or MAP value into a struct, slice or map
+// field.
+type rowScanner struct {
+	dst    reflect.Value
+	strict bool
+}
+
+func (s *rowScanner) Scan(src interface{}) error {
+	switch src := src.(type) {
+	case nil:
+		return s.assign(s.dst, nil)
+	case string:
+		return s.assign(s.dst, src)
+	case []byte:
+		// UNLOAD returns ROW, ARRAY and MAP values as JSON
+		return s.assign(s.dst, json.RawMessage(append([]byte(nil), src...)))
+	default:
+		return fmt.Errorf("cannot scan %T into %s", src, s.dst.Type())
+	}
+}
+
+// assign assigns val to dst. val is nil for NULL, the text rendering of the
+// value in Athena's results or its JSON encoding in UNLOAD files.
+func (s *rowScanner) assign(dst reflect.Value, val interface{}) error {
+	if val == nil {
+		if dst.Kind() == reflect.Pointer || dst.Kind() == reflect.Interface {
+			dst.Set(reflect.Zero(dst.Type()))
+			return nil
+		}
+		if scanner, ok := dst.Addr().Interface().(sql.Scanner); ok {
+			return scanner.Scan(nil)
+		}
+		return fmt.Errorf("cannot scan NULL into %s, use a pointer", dst.Type())
+	}
+
+	if dst.Kind() == reflect.Pointer {
+		elem := reflect.New(dst.Type().Elem())
+		if err := s.assign(elem.Elem(), val); err != nil {
+			return err
+		}
+		dst.Set(elem)
+		return nil
+	}
+
+	if isRowField(dst.Type()) {
+		var (
+			fields map[string]interface{}
+			err    error
+		)
+		switch val := val.(type) {
+		case json.RawMessage:
+			fields, err = parseRowJSON(val)
+		case string:
+			fields, err = parseRowText(val)
+		}
+		if err != nil {
+			return err
+		}
+		return s.assignRow(dst, fields)
+	}
+	if isCollectionField(dst.Type()) {
+		return s.assignCollection(dst, val)
+	}
+
+	text, err := rowValueText(val)
+	if err != nil {
+		return err
+	}
+	if scanner, ok := dst.Addr().Interface().(sql.Scanner); ok {
+		return scanner.Scan(text)
+	}
+	return assignText(dst, text)
+}
+
+func (s *rowScanner) assignRow(dst reflect.Value, values map[string]interface{}) error {
+	fields := structFields(dst.Type())
+	for name, val := range values {
+		index, ok := fields[strings.ToLower(name)]
+		if !ok {
+			if s.strict {
+				return fmt.Errorf("row field %s has no matching field in %s", name, dst.Type())
+			}
+			continue
+		}
+		if err := s.assign(dst.FieldByIndex(index), val); err != nil {
+			return fmt.Errorf("row field %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// assignCollection assigns the ARRAY value val to the slice dst or the MAP
+// value val to the map dst.
+func (s *rowScanner) assignCollection(dst reflect.Value, val interface{}) error {
+	if dst.Kind() == reflect.Slice {
+		var (
+			items []interface{}
+			err   error
+		)
+		switch val := val.(type) {
+		case json.RawMessage:
+			items, err = parseArrayJSON(val)
+		case string:
+			items, err = parseArrayText(val)
+		}
+		if err != nil {
+			return err
+		}
+		slice := reflect.MakeSlice(dst.Type(), len(items), len(items))
+		for i, item := range items {
+			if err := s.assign(slice.Index(i), item); err != nil {
+				return fmt.Errorf("array element %d: %w", i, err)
+			}
+		}
+		dst.Set(slice)
+		return nil
+	}
+
+	var (
+		entries map[string]interface{}
+		err     error
+	)
+	switch val := val.(type) {
+	case json.RawMessage:
+		entries, err = parseRowJSON(val)
+	case string:
+		entries, err = parseMapText(val)
+	}
+	if err != nil {
+		return err
+	}
+	m := reflect.MakeMapWithSize(dst.Type(), len(entries))
+	for k, v := range entries {
+		key := reflect.New(dst.Type().Key()).Elem()
+		if err := assignText(key, k); err != nil {
+			return fmt.Errorf("map key %s: %w", k, err)
+		}
+		elem := reflect.New(dst.Type().Elem()).Elem()
+		if err := s.assign(elem, v); err != nil {
+			return fmt.Errorf("map value of %s: %w", k, err)
+		}
+		m.SetMapIndex(key, elem)
+	}
+	dst.Set(m)
+	return nil
+}
+
+// rowValueText returns the text of a scalar ROW, ARRAY or MAP element.
+func rowValueText(val interface{}) (string, error) {
+	raw, ok := val.(json.RawMessage)
+	if !ok {
+		return val.(string), nil
+	}
+	if len(raw) > 0 && raw[0] == '"' {
+		var text string
+		err := json.Unmarshal(raw, &text)
+		return text, err
+	}
+	return string(raw), nil
+}
+
+// assignText parses text into dst according to its kind.
+func assignText(dst reflect.Value, text string) error {
+	if dst.Type() == typeTime {
+		t, err := parseRowTime(text)
+		if err != nil {
+			return err
+		}
+		dst.Set(reflect.ValueOf(t))
+		return nil
+	}
+
+	switch dst.Kind() {
+	case reflect.String:
+		dst.SetString(text)
+	case reflect.Slice:
+		if dst.Type().Elem().Kind() != reflect.Uint8 {
+			return fmt.Errorf("cannot scan %q into %s", text, dst.Type())
+		}
+		dst.SetBytes([]byte(text))
+	case reflect.Bool:
+		b, err := strconv.ParseBool(text)
+		if err != nil {
+			return err
+		}
+		dst.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(text, 10, dst.Type().Bits())
+		if err != nil {
+			return err
+		}
+		dst.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(text, 10, dst.Type().Bits())
+		if err != nil {
+			return err
+		}
+		dst.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(text, dst.Type().Bits())
+		if err != nil {
+			return err
+		}
+		dst.SetFloat(f)
+	case reflect.Interface:
+		if dst.NumMethod() > 0 {
+			return fmt.Errorf("cannot scan %q into %s", text, dst.Type())
+		}
+		dst.Set(reflect.ValueOf(text))
+	default:
+		return fmt.Errorf("cannot scan %q into %s", text, dst.Type())
+	}
+	return nil
+}
+
+// parseRowTime parses a timestamp or date inside a ROW value, whose type
+// isn't known.
+func parseRowTime(text string) (time.Time, error) {
+	for _, layout := range []string{TimestampLayout, DateLayout} {
+		if t, err := time.Parse(layout, text); err == nil {
+			return t, nil
+		}
+	}
+	return parseWithTimeZone(TimestampLayout, text)
+}
+
+// rowFieldStart matches the `name=` starting a field of a ROW value.
+var rowFieldStart = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*=`)
+
+// parseRowText parses Athena's rendering of a ROW value, e.g.
+// `{id=1, name=foo, address={city=Paris}}`, into its field values. Nested
+// ROW values are left as text. As values aren't quoted, a varchar field
+// containing `, name=` can't be told apart from the next field.
+func parseRowText(text string) (map[string]interface{}, error) {
+	if len(text) < 2 || text[0] != '{' || text[len(text)-1] != '}' {
+		return nil, fmt.Errorf("cannot parse '%s' as row", text)
+	}
+	inner := text[1 : len(text)-1]
+
+	var entries []string
+	depth, start := 0, 0
+	for i := 0; i < len(inner); i++ {
+		switch inner[i] {
+		case '{', '[', '(':
+			depth++
+		case '}', ']', ')':
+			depth--
+		case ',':
+			if depth == 0 && strings.HasPrefix(inner[i+1:], " ") && rowFieldStart.MatchString(inner[i+2:]) {
+				entries = append(entries, inner[start:i])
+				start = i + 2
+			}
+		}
+	}
+	if strings.TrimSpace(inner) != "" {
+		entries = append(entries, inner[start:])
+	}
+
+	fields := make(map[string]interface{}, len(entries))
+	for _, entry := range entries {
+		name, val, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("cannot parse '%s' as row", text)
+		}
+		if val == "null" {
+			fields[name] = nil
+		} else {
+			fields[name] = val
+		}
+	}
+	return fields, nil
+}
+
+// parseArrayText parses Athena's rendering of an ARRAY value, e.g.
+// `[1, 2, 3]`, into its elements, splitting it at the top level like
+// parseRowText. Nested values are left as text.
+func parseArrayText(text string) ([]interface{}, error) {
+	parts, err := splitArrowContainer(text, '[', ']')
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse '%s' as array", text)
+	}
+	items := make([]interface{}, len(parts))
+	for i, part := range parts {
+		if part != "null" {
+			items[i] = part
+		}
+	}
+	return items, nil
+}
+
+// parseMapText parses Athena's rendering of a MAP value, e.g.
+// `{a=1, b=2}`, into its entries. Nested values are left as text.
+func parseMapText(text string) (map[string]interface{}, error) {
+	parts, err := splitArrowContainer(text, '{', '}')
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse '%s' as map", text)
+	}
+	entries := make(map[string]interface{}, len(parts))
+	for _, part := range parts {
+		k, v, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("cannot parse '%s' as map", text)
+		}
+		if v == "null" {
+			entries[k] = nil
+		} else {
+			entries[k] = v
+		}
+	}
+	return entries, nil
+}
+
+// parseArrayJSON parses an ARRAY value unloaded as a JSON array.
+func parseArrayJSON(raw json.RawMessage) ([]interface{}, error) {
+	var values []json.RawMessage
+	if err := json.Unmarshal(raw, &values); err != nil {
+		return nil, fmt.Errorf("cannot parse '%s' as array: %w", raw, err)
+	}
+	items := make([]interface{}, len(values))
+	for i, val := range values {
+		if string(val) != "null" {
+			items[i] = val
+		}
+	}
+	return items, nil
+}
+
+// parseRowJSON parses a ROW or MAP value unloaded as a JSON object.
+func parseRowJSON(raw json.RawMessage) (map[string]interface{}, error) {
+	var values map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &values); err != nil {
+		return nil, fmt.Errorf("cannot parse '%s' as row: %w", raw, err)
+	}
+	fields := make(map[string]interface{}, len(values))
+	for name, val := range values {
+		if string(val) == "null" {
+			fields[name] = nil
+		} else {
+			fields[name] = val
+		}
+	}
+	return fields, nil
+}
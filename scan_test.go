@@ -0,0 +1,163 @@
+package athena
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vx416/go-athenav/athenamock"
+)
+
+type scanAddress struct {
+	City string
+	Zip  *int
+	Geo  *struct {
+		Lat float64
+		Lng float64
+	}
+}
+
+type scanBase struct {
+	ID int64
+}
+
+type scanUser struct {
+	scanBase
+	UserName  string
+	Score     *float64
+	Amount    Decimal
+	CreatedAt time.Time `athena:"created"`
+	Address   scanAddress
+	Ignored   string `athena:"-"`
+}
+
+func mockScanDB(t *testing.T, columnNames, columnTypes []string, rows [][]string) *sql.DB {
+	mockAPI := athenamock.AthenaAPI{}
+	MockQuery(&mockAPI, columnNames, columnTypes, rows)
+	return openMockDB(t, &mockAPI)
+}
+
+func TestQueryStructs(t *testing.T) {
+	columnNames := []string{"id", "user_name", "score", "amount", "created", "address", "ignored", "extra"}
+	columnTypes := []string{"integer", "varchar", "double", "decimal(10,2)", "timestamp", "row(city varchar, zip integer, geo row(lat double, lng double))", "varchar", "varchar"}
+	rows := [][]string{
+		{"1", "vic", "1.5", "10.25", "2024-01-02 03:04:05.000", "{city=Paris, zip=75001, geo={lat=1.5, lng=2.5}}", "x", "y"},
+		{"2", "bob", "2", "0.10", "2024-01-03 00:00:00.000", "{city=a, b, zip=null, geo=null}", "x", "y"},
+	}
+	db := mockScanDB(t, columnNames, columnTypes, rows)
+
+	users, err := QueryStructs[scanUser](context.Background(), db, "SELECT * FROM users")
+	require.NoError(t, err)
+	require.Len(t, users, 2)
+
+	zip := 75001
+	assert.Equal(t, int64(1), users[0].ID)
+	assert.Equal(t, "vic", users[0].UserName)
+	assert.Equal(t, 1.5, *users[0].Score)
+	assert.Equal(t, "10.25", users[0].Amount.String())
+	assert.Equal(t, time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), users[0].CreatedAt)
+	assert.Equal(t, "Paris", users[0].Address.City)
+	assert.Equal(t, &zip, users[0].Address.Zip)
+	require.NotNil(t, users[0].Address.Geo)
+	assert.Equal(t, 2.5, users[0].Address.Geo.Lng)
+	assert.Empty(t, users[0].Ignored)
+
+	assert.Equal(t, scanAddress{City: "a, b"}, users[1].Address)
+
+	db = mockScanDB(t, columnNames, columnTypes, rows)
+	_, err = QueryStructs[scanUser](WithStrictScan(context.Background()), db, "SELECT * FROM users")
+	assert.ErrorContains(t, err, "column ignored has no matching field")
+}
+
+func TestQueryRowStruct(t *testing.T) {
+	db := mockScanDB(t, []string{"id"}, []string{"bigint"}, [][]string{{"1"}, {"2"}})
+	base, err := QueryRowStruct[scanBase](context.Background(), db, "SELECT id FROM users")
+	require.NoError(t, err)
+	assert.Equal(t, scanBase{ID: 1}, base)
+
+	db = mockScanDB(t, []string{"id"}, []string{"bigint"}, nil)
+	_, err = QueryRowStruct[scanBase](context.Background(), db, "SELECT id FROM users")
+	assert.Equal(t, sql.ErrNoRows, err)
+}
+
+func TestRowScanner_JSON(t *testing.T) {
+	var addr scanAddress
+	s := &rowScanner{dst: reflect.ValueOf(&addr).Elem(), strict: true}
+	require.NoError(t, s.Scan([]byte(`{"city":"Paris","zip":75001,"geo":{"lat":1.5,"lng":2.5}}`)))
+	assert.Equal(t, "Paris", addr.City)
+	assert.Equal(t, 75001, *addr.Zip)
+	assert.Equal(t, 1.5, addr.Geo.Lat)
+
+	assert.ErrorContains(t, s.Scan([]byte(`{"country":"FR"}`)), "row field country has no matching field")
+}
+
+type scanCollections struct {
+	Tags      []string
+	Scores    map[string]int
+	Matrix    [][]float64
+	Addresses []scanAddress
+	Zips      *[]*int
+	Counts    map[int]map[string]bool
+}
+
+func TestQueryStructs_ArraysAndMaps(t *testing.T) {
+	columnNames := []string{"tags", "scores", "matrix", "addresses", "zips", "counts"}
+	columnTypes := []string{"array(varchar)", "map(varchar, integer)", "array(array(double))",
+		"array(row(city varchar, zip integer))", "array(integer)", "map(integer, map(varchar, boolean))"}
+	rows := [][]string{
+		{"[a, b]", "{x=1, y=2}", "[[1.5, 2.0], []]", "[{city=Paris, zip=75001}, {city=Rome, zip=null}]", "[1, null]", "{1={ok=true}}"},
+		{"[]", "{}", "[]", "[]", "[]", "{}"},
+	}
+	db := mockScanDB(t, columnNames, columnTypes, rows)
+
+	got, err := QueryStructs[scanCollections](context.Background(), db, "SELECT * FROM t")
+	require.NoError(t, err)
+	require.Len(t, got, 2)
+
+	zip, one := 75001, 1
+	assert.Equal(t, []string{"a", "b"}, got[0].Tags)
+	assert.Equal(t, map[string]int{"x": 1, "y": 2}, got[0].Scores)
+	assert.Equal(t, [][]float64{{1.5, 2.0}, {}}, got[0].Matrix)
+	assert.Equal(t, []scanAddress{{City: "Paris", Zip: &zip}, {City: "Rome"}}, got[0].Addresses)
+	assert.Equal(t, &[]*int{&one, nil}, got[0].Zips)
+	assert.Equal(t, map[int]map[string]bool{1: {"ok": true}}, got[0].Counts)
+
+	assert.Equal(t, scanCollections{
+		Tags:      []string{},
+		Scores:    map[string]int{},
+		Matrix:    [][]float64{},
+		Addresses: []scanAddress{},
+		Zips:      &[]*int{},
+		Counts:    map[int]map[string]bool{},
+	}, got[1])
+}
+
+func TestRowScanner_JSONCollections(t *testing.T) {
+	var got scanCollections
+	v := reflect.ValueOf(&got).Elem()
+	require.NoError(t, (&rowScanner{dst: v.Field(0)}).Scan([]byte(`["a, b","c"]`)))
+	require.NoError(t, (&rowScanner{dst: v.Field(1)}).Scan([]byte(`{"x":1}`)))
+	require.NoError(t, (&rowScanner{dst: v.Field(3)}).Scan([]byte(`[{"city":"Paris"}]`)))
+	assert.Equal(t, []string{"a, b", "c"}, got.Tags)
+	assert.Equal(t, map[string]int{"x": 1}, got.Scores)
+	assert.Equal(t, []scanAddress{{City: "Paris"}}, got.Addresses)
+
+	require.NoError(t, (&rowScanner{dst: v.Field(4)}).Scan(nil))
+	assert.Nil(t, got.Zips)
+}
+
+func TestToSnakeCase(t *testing.T) {
+	for in, expected := range map[string]string{
+		"ID":         "id",
+		"UserID":     "user_id",
+		"HTTPServer": "http_server",
+		"Address2":   "address2",
+		"createdAt":  "created_at",
+	} {
+		assert.Equal(t, expected, toSnakeCase(in), in)
+	}
+}
@@ -0,0 +1,99 @@
+package athena
+
+import (
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/athena"
+)
+
+// utilityColumns are the columns of DDL and utility statements whose results
+// Athena returns as a single, often unnamed, tab-separated varchar column.
+// They are keyed by QueryExecution.SubstatementType.
+var utilityColumns = map[string][]string{
+	"SHOW_TABLES":        {"tab_name"},
+	"SHOW_VIEWS":         {"views"},
+	"SHOW_DATABASES":     {"database_name"},
+	"SHOW_SCHEMAS":       {"database_name"},
+	"SHOW_PARTITIONS":    {"partition"},
+	"SHOW_COLUMNS":       {"field"},
+	"SHOW_CREATE_TABLE":  {"createtab_stmt"},
+	"SHOW_CREATE_VIEW":   {"createview_stmt"},
+	"SHOW_TBLPROPERTIES": {"prpt_name", "prpt_value"},
+	"DESCRIBE":           {"col_name", "data_type", "comment"},
+	"EXPLAIN":            {"Query Plan"},
+}
+
+// hasHeaderRow reports whether the first result row of exec holds the column
+// names rather than data. Only DML statements, e.g. SELECT, return a header.
+func hasHeaderRow(exec *athena.QueryExecution) bool {
+	// The statement type is unknown, e.g. when mocked, so assume a SELECT.
+	if exec == nil || exec.StatementType == nil {
+		return true
+	}
+	if _, ok := utilityColumnsOf(exec); ok {
+		return false
+	}
+	return *exec.StatementType == athena.StatementTypeDml
+}
+
+// utilityColumnsOf returns the columns of exec if it is a DDL or utility
+// statement with a known result layout.
+func utilityColumnsOf(exec *athena.QueryExecution) ([]string, bool) {
+	if exec == nil || exec.SubstatementType == nil {
+		return nil, false
+	}
+
+	substatement := strings.ToUpper(strings.ReplaceAll(*exec.SubstatementType, " ", "_"))
+	if columns, ok := utilityColumns[substatement]; ok {
+		return columns, true
+	}
+	for _, prefix := range []string{"DESCRIBE", "EXPLAIN"} {
+		if strings.HasPrefix(substatement, prefix) {
+			return utilityColumns[prefix], true
+		}
+	}
+	return nil, false
+}
+
+// utilityColumnInfo builds varchar column metadata for the given names.
+func utilityColumnInfo(names []string) []*athena.ColumnInfo {
+	infos := make([]*athena.ColumnInfo, 0, len(names))
+	for _, name := range names {
+		infos = append(infos, &athena.ColumnInfo{
+			Name:     aws.String(name),
+			Label:    aws.String(name),
+			Type:     aws.String("varchar"),
+			Nullable: aws.String(athena.ColumnNullableNullable),
+		})
+	}
+	return infos
+}
+
+// splitUtilityRows splits the single tab-separated column of utility rows into
+// the given number of columns. Blank lines, which DESCRIBE uses as section
+// separators, are dropped.
+func splitUtilityRows(in []*athena.Row, columns int) []*athena.Row {
+	out := make([]*athena.Row, 0, len(in))
+	for _, row := range in {
+		if row == nil || len(row.Data) != 1 || columns == 1 {
+			out = append(out, row)
+			continue
+		}
+
+		line := aws.StringValue(row.Data[0].VarCharValue)
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		fields := strings.SplitN(line, "\t", columns)
+		data := make([]*athena.Datum, columns)
+		for i := range data {
+			data[i] = &athena.Datum{}
+			if i < len(fields) {
+				data[i].VarCharValue = aws.String(strings.TrimSpace(fields[i]))
+			}
+		}
+		out = append(out, &athena.Row{Data: data})
+	}
+	return out
+}
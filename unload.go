@@ -0,0 +1,400 @@
+package athena
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	uuid "github.com/satori/go.uuid"
+)
+
+const (
+	// UnloadFormatParquet unloads results as Parquet files, whose schema
+	// provides the column types of the result.
+	UnloadFormatParquet = "PARQUET"
+	// UnloadFormatJSON unloads results as gzipped JSON Lines. JSON carries no
+	// column types, so they are taken from a `LIMIT 0` query run alongside.
+	UnloadFormatJSON = "JSON"
+)
+
+// UnloadOptions configures UNLOAD-based result retrieval, see WithUnload.
+type UnloadOptions struct {
+	// Format is the UNLOAD file format. Defaults to UnloadFormatParquet.
+	Format string
+	// Location is the empty S3 prefix the files are written to. Defaults to
+	// a unique prefix under the connection's output location.
+	Location string
+	// KeepFiles keeps the files after the rows are closed instead of
+	// deleting them.
+	KeepFiles bool
+}
+
+type unloadOptionsKey struct{}
+
+// WithUnload returns a context that makes queries run with it wrap their
+// SELECT in `UNLOAD (...) TO '<location>'` and stream the written files back
+// as rows. This bypasses the 1000 rows per page limit of GetQueryResults and
+// keeps numbers and booleans typed, which makes it the fastest way to
+// retrieve large results. The files are deleted when the rows are closed.
+//
+// Only queries returning rows, e.g. SELECT, can be unloaded.
+func WithUnload(ctx context.Context, opts UnloadOptions) context.Context {
+	return context.WithValue(ctx, unloadOptionsKey{}, opts)
+}
+
+func unloadOptionsFromContext(ctx context.Context) (UnloadOptions, bool) {
+	opts, ok := ctx.Value(unloadOptionsKey{}).(UnloadOptions)
+	return opts, ok
+}
+
+// runUnload runs query as an UNLOAD and returns rows streaming the files it
+// wrote.
+func (c *conn) runUnload(ctx context.Context, query string, args []driver.NamedValue, opts UnloadOptions) (driver.Rows, error) {
+	if c.s3 == nil {
+		return nil, errors.New("unload requires an S3 client")
+	}
+	format := strings.ToUpper(opts.Format)
+	switch format {
+	case "":
+		format = UnloadFormatParquet
+	case UnloadFormatParquet, UnloadFormatJSON:
+	default:
+		return nil, fmt.Errorf("unsupported unload format: %s", opts.Format)
+	}
+	if opts.Location == "" {
+		opts.Location = fmt.Sprintf("%s/unload/%s/", strings.TrimSuffix(c.OutputLocation, "/"), uuid.NewV4())
+	}
+	bucket, prefix, err := parseS3URL(opts.Location)
+	if err != nil {
+		return nil, err
+	}
+
+	query = strings.TrimRight(strings.TrimSpace(query), ";")
+	if !isSelectQuery(query) {
+		return nil, errors.New("only SELECT queries can be unloaded")
+	}
+
+	unloadQuery := fmt.Sprintf("UNLOAD (%s) TO '%s' WITH (format = '%s')", query, opts.Location, format)
+	if format == UnloadFormatJSON {
+		unloadQuery = fmt.Sprintf("UNLOAD (%s) TO '%s' WITH (format = '%s', compression = 'GZIP')", query, opts.Location, format)
+	}
+	unloadID, err := c.startQuery(unloadQuery, args)
+	if err != nil {
+		return nil, err
+	}
+	var metadataID string
+	if format == UnloadFormatJSON {
+		if metadataID, err = c.startQuery(metadataQuery(query), args); err != nil {
+			c.abandonQuery(unloadID)
+			return nil, err
+		}
+	}
+
+	files := &unloadFiles{s3: c.s3, bucket: bucket, prefix: prefix, keep: opts.KeepFiles}
+	if _, err := c.waitOnQuery(ctx, unloadID); err != nil {
+		if metadataID != "" {
+			c.abandonQuery(metadataID)
+		}
+		files.cleanup(context.WithoutCancel(ctx))
+		return nil, err
+	}
+	keys, err := files.list(ctx)
+	if err != nil {
+		if metadataID != "" {
+			c.abandonQuery(metadataID)
+		}
+		files.cleanup(context.WithoutCancel(ctx))
+		return nil, err
+	}
+
+	r := &unloadRows{ctx: ctx, files: files, keys: keys, loc: c.loc}
+	if format == UnloadFormatParquet {
+		r.open = r.openParquetFile
+		if len(keys) > 0 {
+			// the schema of the first file provides the columns
+			if err := r.openFile(); err != nil {
+				r.Close()
+				return nil, err
+			}
+		} else if metadataID, err = c.startQuery(metadataQuery(query), args); err != nil {
+			// an empty result writes no file to take the columns from
+			files.cleanup(context.WithoutCancel(ctx))
+			return nil, err
+		}
+	} else {
+		r.open = r.openJSONFile
+	}
+	if metadataID != "" {
+		if r.columns, err = c.metadataColumns(ctx, metadataID); err != nil {
+			r.Close()
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// metadataQuery returns a query returning no rows but the columns of query.
+func metadataQuery(query string) string {
+	return fmt.Sprintf("SELECT * FROM (%s) LIMIT 0", query)
+}
+
+// metadataColumns waits on the query started with metadataQuery and returns
+// its columns.
+func (c *conn) metadataColumns(ctx context.Context, queryID string) ([]*athena.ColumnInfo, error) {
+	if _, err := c.waitOnQuery(ctx, queryID); err != nil {
+		return nil, err
+	}
+	out, err := c.athena.GetQueryResultsWithContext(ctx, &athena.GetQueryResultsInput{
+		QueryExecutionId: aws.String(queryID),
+		MaxResults:       aws.Int64(1),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if out.ResultSet == nil || out.ResultSet.ResultSetMetadata == nil {
+		return nil, fmt.Errorf("query %s has no result metadata", queryID)
+	}
+	return out.ResultSet.ResultSetMetadata.ColumnInfo, nil
+}
+
+// abandonQuery stops a started query whose result is no longer needed.
+func (c *conn) abandonQuery(queryID string) {
+	defer c.tracker.remove(queryID)
+	stopQuery(c.athena, queryID)
+}
+
+// isSelectQuery reports whether query returns rows, i.e. is a SELECT, a
+// WITH ... SELECT, a VALUES or a TABLE query.
+func isSelectQuery(query string) bool {
+	fields := strings.Fields(strings.TrimLeft(query, "( \t\r\n"))
+	if len(fields) == 0 {
+		return false
+	}
+	switch strings.ToUpper(fields[0]) {
+	case "SELECT", "WITH", "VALUES", "TABLE":
+		return true
+	}
+	return false
+}
+
+// unloadFiles are the files an UNLOAD wrote under an S3 prefix.
+type unloadFiles struct {
+	s3     s3iface.S3API
+	bucket string
+	prefix string
+	keep   bool
+}
+
+func (f *unloadFiles) list(ctx context.Context) ([]string, error) {
+	var keys []string
+	err := f.s3.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket: aws.String(f.bucket),
+		Prefix: aws.String(f.prefix),
+	}, func(out *s3.ListObjectsV2Output, _ bool) bool {
+		for _, obj := range out.Contents {
+			if key := aws.StringValue(obj.Key); !strings.HasSuffix(key, "/") {
+				keys = append(keys, key)
+			}
+		}
+		return true
+	})
+	sort.Strings(keys)
+	return keys, err
+}
+
+// cleanup deletes every file under the prefix unless they should be kept.
+// Queries failing on their context pass context.WithoutCancel of it, as
+// their files still have to be deleted.
+func (f *unloadFiles) cleanup(ctx context.Context) error {
+	if f.keep {
+		return nil
+	}
+	keys, err := f.list(ctx)
+	if err != nil {
+		return err
+	}
+	for len(keys) > 0 {
+		// DeleteObjects accepts at most 1000 keys per call
+		batch := keys
+		if len(batch) > 1000 {
+			batch = batch[:1000]
+		}
+		keys = keys[len(batch):]
+
+		objects := make([]*s3.ObjectIdentifier, 0, len(batch))
+		for _, key := range batch {
+			objects = append(objects, &s3.ObjectIdentifier{Key: aws.String(key)})
+		}
+		if _, err := f.s3.DeleteObjectsWithContext(ctx, &s3.DeleteObjectsInput{
+			Bucket: aws.String(f.bucket),
+			Delete: &s3.Delete{Objects: objects, Quiet: aws.Bool(true)},
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unloadRows streams the files written by an UNLOAD.
+type unloadRows struct {
+	ctx   context.Context
+	files *unloadFiles
+	keys  []string
+	loc   *time.Location
+
+	// open opens the next file of keys as file.
+	open func(key string) error
+	file unloadFile
+	resultColumns
+}
+
+// unloadFile reads the records of an unloaded file.
+type unloadFile interface {
+	// next reads the next record into dest, or returns io.EOF at the end of
+	// the file.
+	next(dest []driver.Value) error
+	close()
+}
+
+func (r *unloadRows) Next(dest []driver.Value) error {
+	for {
+		if r.file == nil {
+			if len(r.keys) == 0 {
+				return io.EOF
+			}
+			if err := r.openFile(); err != nil {
+				return err
+			}
+		}
+
+		err := r.file.next(dest)
+		if err == io.EOF {
+			r.closeFile()
+			continue
+		}
+		return err
+	}
+}
+
+// openFile opens the first of the files left.
+func (r *unloadRows) openFile() error {
+	if err := r.open(r.keys[0]); err != nil {
+		return err
+	}
+	r.keys = r.keys[1:]
+	return nil
+}
+
+func (r *unloadRows) getObject(key string) (io.ReadCloser, error) {
+	obj, err := r.files.s3.GetObjectWithContext(r.ctx, &s3.GetObjectInput{
+		Bucket: aws.String(r.files.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return obj.Body, nil
+}
+
+func (r *unloadRows) openJSONFile(key string) error {
+	body, err := r.getObject(key)
+	if err != nil {
+		return err
+	}
+
+	var reader io.Reader = bufio.NewReader(body)
+	if magic, _ := reader.(*bufio.Reader).Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		if reader, err = gzip.NewReader(reader); err != nil {
+			body.Close()
+			return err
+		}
+	}
+	dec := json.NewDecoder(reader)
+	dec.UseNumber()
+	r.file = &jsonUnloadFile{body: body, dec: dec, columns: r.columns, loc: r.loc}
+	return nil
+}
+
+func (r *unloadRows) closeFile() {
+	if r.file != nil {
+		r.file.close()
+	}
+	r.file = nil
+}
+
+// jsonUnloadFile reads a JSON Lines file.
+type jsonUnloadFile struct {
+	body    io.ReadCloser
+	dec     *json.Decoder
+	columns []*athena.ColumnInfo
+	loc     *time.Location
+}
+
+func (f *jsonUnloadFile) next(dest []driver.Value) error {
+	var record map[string]json.RawMessage
+	if err := f.dec.Decode(&record); err != nil {
+		return err
+	}
+	for i, colInfo := range f.columns {
+		var err error
+		dest[i], err = convertJSONValue(aws.StringValue(colInfo.Type), record[aws.StringValue(colInfo.Name)], f.loc)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *jsonUnloadFile) close() {
+	f.body.Close()
+}
+
+// Close deletes the unloaded files unless UnloadOptions.KeepFiles is set.
+func (r *unloadRows) Close() error {
+	r.closeFile()
+	r.keys = nil
+	return r.files.cleanup(context.Background())
+}
+
+var (
+	_ driver.RowsColumnTypeDatabaseTypeName = (*unloadRows)(nil)
+	_ driver.RowsColumnTypeScanType         = (*unloadRows)(nil)
+	_ driver.RowsColumnTypeNullable         = (*unloadRows)(nil)
+	_ driver.RowsColumnTypeLength           = (*unloadRows)(nil)
+	_ driver.RowsColumnTypePrecisionScale   = (*unloadRows)(nil)
+)
+
+// convertJSONValue converts a value of an UNLOAD JSON record. Scalars are
+// converted like query results, arrays, maps and rows are returned as raw
+// JSON.
+func convertJSONValue(athenaType string, raw json.RawMessage, loc *time.Location) (interface{}, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+
+	switch raw[0] {
+	case '[', '{':
+		return []byte(raw), nil
+	case '"':
+		var val string
+		if err := json.Unmarshal(raw, &val); err != nil {
+			return nil, err
+		}
+		return convertValue(athenaType, &val, loc)
+	default:
+		val := string(raw)
+		return convertValue(athenaType, &val, loc)
+	}
+}
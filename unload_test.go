@@ -0,0 +1,219 @@
+package athena
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/apache/arrow/go/v15/arrow"
+	"github.com/apache/arrow/go/v15/arrow/array"
+	"github.com/apache/arrow/go/v15/arrow/decimal128"
+	"github.com/apache/arrow/go/v15/arrow/memory"
+	"github.com/apache/arrow/go/v15/parquet/pqarrow"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeUnloadAthena struct {
+	fakeS3ModeAthena
+	queries []string
+	// failing makes the queries starting with it fail to start.
+	failing string
+	stopped []string
+}
+
+func (f *fakeUnloadAthena) StartQueryExecution(input *athena.StartQueryExecutionInput) (*athena.StartQueryExecutionOutput, error) {
+	if f.failing != "" && strings.HasPrefix(*input.QueryString, f.failing) {
+		return nil, errors.New("cannot start query")
+	}
+	f.queries = append(f.queries, *input.QueryString)
+	return &athena.StartQueryExecutionOutput{QueryExecutionId: aws.String(fmt.Sprintf("query-%d", len(f.queries)))}, nil
+}
+
+func (f *fakeUnloadAthena) StopQueryExecutionWithContext(_ aws.Context, input *athena.StopQueryExecutionInput, _ ...request.Option) (*athena.StopQueryExecutionOutput, error) {
+	f.stopped = append(f.stopped, *input.QueryExecutionId)
+	return &athena.StopQueryExecutionOutput{}, nil
+}
+
+func (f *fakeUnloadAthena) GetQueryExecutionWithContext(aws.Context, *athena.GetQueryExecutionInput, ...request.Option) (*athena.GetQueryExecutionOutput, error) {
+	return &athena.GetQueryExecutionOutput{QueryExecution: &athena.QueryExecution{
+		Status: &athena.QueryExecutionStatus{State: aws.String(athena.QueryExecutionStateSucceeded)},
+	}}, nil
+}
+
+func gzipString(t *testing.T, s string) string {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	_, err := w.Write([]byte(s))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return buf.String()
+}
+
+func TestConn_Unload(t *testing.T) {
+	client := &fakeUnloadAthena{fakeS3ModeAthena: fakeS3ModeAthena{columns: []*athena.ColumnInfo{
+		{Name: aws.String("id"), Type: aws.String("bigint")},
+		{Name: aws.String("name"), Type: aws.String("varchar")},
+		{Name: aws.String("ts"), Type: aws.String("timestamp")},
+		{Name: aws.String("tags"), Type: aws.String("array(varchar)")},
+	}}}
+	s3 := &fakeS3{objects: map[string]string{
+		"results/unload/part-1.gz": gzipString(t, `{"id":1,"name":"a","ts":"2024-01-02 03:04:05.000","tags":["x"]}`+"\n"+`{"id":2}`+"\n"),
+		"results/unload/part-2.gz": gzipString(t, `{"id":3,"name":"c"}`+"\n"),
+		"results/other/keep.csv":   "",
+	}}
+	c := &conn{athena: client, s3: s3, pollFrequency: defaultPollFrequency}
+
+	ctx := WithUnload(context.Background(), UnloadOptions{Format: UnloadFormatJSON, Location: "s3://results/unload/"})
+	driverRows, err := c.QueryContext(ctx, "SELECT id, name, ts, tags FROM t WHERE id > ?;", []driver.NamedValue{{Ordinal: 1, Value: 0}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"UNLOAD (SELECT id, name, ts, tags FROM t WHERE id > ?) TO 's3://results/unload/' WITH (format = 'JSON', compression = 'GZIP')",
+		"SELECT * FROM (SELECT id, name, ts, tags FROM t WHERE id > ?) LIMIT 0",
+	}, client.queries)
+	assert.Equal(t, []string{"id", "name", "ts", "tags"}, driverRows.Columns())
+
+	var got [][]driver.Value
+	for {
+		dest := make([]driver.Value, 4)
+		if err := driverRows.Next(dest); err != nil {
+			require.Equal(t, io.EOF, err)
+			break
+		}
+		got = append(got, dest)
+	}
+	require.Len(t, got, 3)
+	assert.Equal(t, []driver.Value{int64(1), "a", time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), []byte(`["x"]`)}, got[0])
+	assert.Equal(t, []driver.Value{int64(2), nil, nil, nil}, got[1])
+	assert.Equal(t, []driver.Value{int64(3), "c", nil, nil}, got[2])
+
+	require.NoError(t, driverRows.Close())
+	assert.Equal(t, map[string]string{"results/other/keep.csv": ""}, s3.objects)
+
+	_, err = c.QueryContext(WithUnload(context.Background(), UnloadOptions{Format: "ORC"}), "SELECT 1", nil)
+	assert.Error(t, err)
+	_, err = c.QueryContext(WithUnload(context.Background(), UnloadOptions{Location: "s3://results/x/"}), "DROP TABLE t", nil)
+	assert.True(t, err != nil && strings.Contains(err.Error(), "only SELECT"))
+}
+
+func parquetFile(t *testing.T, rec arrow.Record) string {
+	var buf bytes.Buffer
+	w, err := pqarrow.NewFileWriter(rec.Schema(), &buf, nil, pqarrow.DefaultWriterProps())
+	require.NoError(t, err)
+	require.NoError(t, w.Write(rec))
+	require.NoError(t, w.Close())
+	return buf.String()
+}
+
+func TestConn_UnloadParquet(t *testing.T) {
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "id", Type: arrow.PrimitiveTypes.Int64},
+		{Name: "name", Type: arrow.BinaryTypes.String, Nullable: true},
+		{Name: "ts", Type: &arrow.TimestampType{Unit: arrow.Microsecond}, Nullable: true},
+		{Name: "amount", Type: &arrow.Decimal128Type{Precision: 10, Scale: 2}, Nullable: true},
+		{Name: "tags", Type: arrow.ListOf(arrow.BinaryTypes.String), Nullable: true},
+	}, nil)
+	b := array.NewRecordBuilder(memory.DefaultAllocator, schema)
+	defer b.Release()
+	b.Field(0).(*array.Int64Builder).AppendValues([]int64{1, 2}, nil)
+	b.Field(1).(*array.StringBuilder).AppendValues([]string{"a", ""}, []bool{true, false})
+	b.Field(2).(*array.TimestampBuilder).AppendValues([]arrow.Timestamp{
+		arrow.Timestamp(time.Date(2024, 1, 2, 3, 4, 5, 123456000, time.UTC).UnixMicro()), 0,
+	}, []bool{true, false})
+	b.Field(3).(*array.Decimal128Builder).AppendValues([]decimal128.Num{decimal128.FromI64(1050), {}}, []bool{true, false})
+	tags := b.Field(4).(*array.ListBuilder)
+	tags.Append(true)
+	tags.ValueBuilder().(*array.StringBuilder).AppendValues([]string{"x", "y"}, nil)
+	tags.AppendNull()
+	rec := b.NewRecord()
+	defer rec.Release()
+
+	client := &fakeUnloadAthena{}
+	s3 := &fakeS3{objects: map[string]string{
+		"results/unload/part-1": parquetFile(t, rec),
+	}}
+	c := &conn{athena: client, s3: s3, pollFrequency: defaultPollFrequency}
+
+	ctx := WithUnload(context.Background(), UnloadOptions{Location: "s3://results/unload/"})
+	driverRows, err := c.QueryContext(ctx, "SELECT id, name, ts, amount, tags FROM t", nil)
+	require.NoError(t, err)
+	// the columns come from the Parquet schema, without a metadata query
+	assert.Equal(t, []string{
+		"UNLOAD (SELECT id, name, ts, amount, tags FROM t) TO 's3://results/unload/' WITH (format = 'PARQUET')",
+	}, client.queries)
+	assert.Equal(t, []string{"id", "name", "ts", "amount", "tags"}, driverRows.Columns())
+	r := driverRows.(*unloadRows)
+	assert.Equal(t, "decimal", r.ColumnTypeDatabaseTypeName(3))
+	assert.Equal(t, "array(varchar)", r.ColumnTypeDatabaseTypeName(4))
+
+	var got [][]driver.Value
+	for {
+		dest := make([]driver.Value, 5)
+		if err := driverRows.Next(dest); err != nil {
+			require.Equal(t, io.EOF, err)
+			break
+		}
+		got = append(got, dest)
+	}
+	assert.Equal(t, [][]driver.Value{
+		{int64(1), "a", time.Date(2024, 1, 2, 3, 4, 5, 123456000, time.UTC), "10.50", []byte(`["x","y"]`)},
+		{int64(2), nil, nil, nil, nil},
+	}, got)
+	require.NoError(t, driverRows.Close())
+	assert.Empty(t, s3.objects)
+}
+
+func TestConn_UnloadParquet_EmptyResult(t *testing.T) {
+	client := &fakeUnloadAthena{fakeS3ModeAthena: fakeS3ModeAthena{columns: []*athena.ColumnInfo{
+		{Name: aws.String("id"), Type: aws.String("bigint")},
+	}}}
+	c := &conn{athena: client, s3: &fakeS3{objects: map[string]string{}}, pollFrequency: defaultPollFrequency}
+
+	ctx := WithUnload(context.Background(), UnloadOptions{Location: "s3://results/unload/"})
+	driverRows, err := c.QueryContext(ctx, "SELECT id FROM t", nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"UNLOAD (SELECT id FROM t) TO 's3://results/unload/' WITH (format = 'PARQUET')",
+		"SELECT * FROM (SELECT id FROM t) LIMIT 0",
+	}, client.queries)
+	assert.Equal(t, []string{"id"}, driverRows.Columns())
+	assert.Equal(t, io.EOF, driverRows.Next(make([]driver.Value, 1)))
+	assert.NoError(t, driverRows.Close())
+}
+
+func TestConn_UnloadStopsQueryOnError(t *testing.T) {
+	client := &fakeUnloadAthena{failing: "SELECT"}
+	c := &conn{athena: client, s3: &fakeS3{}, pollFrequency: defaultPollFrequency, tracker: newQueryTracker()}
+
+	ctx := WithUnload(context.Background(), UnloadOptions{Format: UnloadFormatJSON, Location: "s3://results/unload/"})
+	_, err := c.QueryContext(ctx, "SELECT id FROM t", nil)
+	assert.EqualError(t, err, "cannot start query")
+	assert.Equal(t, []string{"query-1"}, client.stopped)
+	assert.Empty(t, c.tracker.running)
+}
+
+func TestConn_UnloadCleansUpAfterCancel(t *testing.T) {
+	client := &fakeUnloadAthena{}
+	s3 := &fakeS3{objects: map[string]string{"results/unload/part-1.gz": ""}}
+	c := &conn{athena: client, s3: s3, pollFrequency: defaultPollFrequency, tracker: newQueryTracker()}
+
+	ctx, cancel := context.WithCancel(WithUnload(context.Background(), UnloadOptions{Format: UnloadFormatJSON, Location: "s3://results/unload/"}))
+	cancel()
+	_, err := c.QueryContext(ctx, "SELECT id FROM t", nil)
+	var aerr awserr.Error
+	require.ErrorAs(t, err, &aerr)
+	assert.Equal(t, request.CanceledErrorCode, aerr.Code())
+	// the files are deleted even though the query's context is done
+	assert.Empty(t, s3.objects)
+}
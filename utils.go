@@ -2,6 +2,7 @@ package athena
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"time"
 
@@ -30,6 +31,33 @@ func (t AthenaDate) Equal(t2 AthenaDate) bool {
 	return time.Time(t).Equal(time.Time(t2))
 }
 
+// Scan implements sql.Scanner so date columns can be scanned into an
+// AthenaDate, including as a QueryStructs field.
+func (t *AthenaDate) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case time.Time:
+		*t = AthenaDate(v)
+	case string:
+		return t.parse(v)
+	case []byte:
+		return t.parse(string(v))
+	case nil:
+		return errors.New("cannot scan NULL into AthenaDate")
+	default:
+		return fmt.Errorf("cannot scan %T into AthenaDate", src)
+	}
+	return nil
+}
+
+func (t *AthenaDate) parse(s string) error {
+	parsed, err := time.Parse(DateLayout, s)
+	if err != nil {
+		return err
+	}
+	*t = AthenaDate(parsed)
+	return nil
+}
+
 func (t AthenaDate) ToQueryValue() string {
 	return fmt.Sprintf("date '%s'", t.String())
 }
@@ -1,24 +1,37 @@
 package athena
 
 import (
+	"database/sql"
 	"database/sql/driver"
+	"encoding/hex"
 	"fmt"
+	"net"
+	"reflect"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
+	"unicode"
 
 	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/vx416/go-athenav/internal/athenafmt"
 )
 
 const (
 	// TimestampLayout is the Go time layout string for an Athena `timestamp`.
-	TimestampLayout             = "2006-01-02 15:04:05.999"
-	TimestampWithTimeZoneLayout = "2006-01-02 15:04:05.999 MST"
-	DateLayout                  = "2006-01-02"
+	TimestampLayout = athenafmt.TimestampLayout
+	DateLayout      = athenafmt.DateLayout
+	// TimeLayout is the Go time layout string for an Athena `time`.
+	TimeLayout = athenafmt.TimeLayout
 )
 
-func convertRow(columns []*athena.ColumnInfo, in []*athena.Datum, ret []driver.Value) error {
+// zoneCache caches the *time.Location of zone IDs seen in query results, as
+// time.LoadLocation reads the zoneinfo database on every call.
+var zoneCache sync.Map
+
+func convertRow(columns []*athena.ColumnInfo, in []*athena.Datum, ret []driver.Value, loc *time.Location) error {
 	for i, val := range in {
-		coerced, err := convertValue(*columns[i].Type, val.VarCharValue)
+		coerced, err := convertValue(*columns[i].Type, val.VarCharValue, loc)
 		if err != nil {
 			return err
 		}
@@ -29,13 +42,19 @@ func convertRow(columns []*athena.ColumnInfo, in []*athena.Datum, ret []driver.V
 	return nil
 }
 
-func convertValue(athenaType string, rawValue *string) (interface{}, error) {
+// convertValue converts a raw Athena value into its Go representation.
+// Timestamps and dates without a time zone are interpreted in loc, which
+// defaults to UTC.
+func convertValue(athenaType string, rawValue *string, loc *time.Location) (interface{}, error) {
 	if rawValue == nil {
 		return nil, nil
 	}
+	if loc == nil {
+		loc = time.UTC
+	}
 
 	val := *rawValue
-	switch athenaType {
+	switch normalizeType(athenaType) {
 	case "tinyint":
 		return strconv.ParseInt(val, 10, 8)
 	case "smallint":
@@ -52,19 +71,240 @@ func convertValue(athenaType string, rawValue *string) (interface{}, error) {
 			return false, nil
 		}
 		return nil, fmt.Errorf("cannot parse '%s' as boolean", val)
-	case "float":
+	case "float", "real":
 		return strconv.ParseFloat(val, 32)
-	case "double", "decimal":
+	case "double":
 		return strconv.ParseFloat(val, 64)
-	case "varchar", "string":
+	case "decimal":
+		// decimals are returned as strings so no precision is lost,
+		// scan into a Decimal to work with the exact value.
+		if _, err := ParseDecimal(val); err != nil {
+			return nil, err
+		}
+		return val, nil
+	case "varchar", "char", "string", "uuid":
 		return val, nil
+	case "varbinary":
+		return parseVarbinary(val)
+	case "json":
+		return []byte(val), nil
+	case "ipaddress":
+		ip := net.ParseIP(val)
+		if ip == nil {
+			return nil, fmt.Errorf("cannot parse '%s' as ipaddress", val)
+		}
+		return ip, nil
 	case "timestamp":
-		return time.Parse(TimestampLayout, val)
+		return time.ParseInLocation(TimestampLayout, val, loc)
 	case "timestamp with time zone":
-		return time.Parse(TimestampWithTimeZoneLayout, val)
+		return parseWithTimeZone(TimestampLayout, val)
 	case "date":
-		return time.Parse(DateLayout, val)
+		return time.ParseInLocation(DateLayout, val, loc)
+	case "time":
+		return time.Parse(TimeLayout, val)
+	case "time with time zone":
+		return parseWithTimeZone(TimeLayout, val)
+	case "interval year to month":
+		return parseIntervalYearToMonth(val)
+	case "interval day to second":
+		return parseIntervalDayToSecond(val)
+	case "row", "array", "map":
+		// rows, arrays and maps are returned in Athena's `{name=value, ...}`,
+		// `[a, b]` and `{k=v, ...}` renderings, scan them into a struct,
+		// slice or map field with QueryStructs to access their values.
+		return val, nil
 	default:
 		return nil, fmt.Errorf("unknown type `%s` with value %s", athenaType, val)
 	}
 }
+
+// normalizeType lower-cases athenaType and strips its parameters, so that
+// e.g. `timestamp(3) with time zone` becomes `timestamp with time zone` and
+// `decimal(38,9)` becomes `decimal`.
+func normalizeType(athenaType string) string {
+	return athenafmt.NormalizeType(athenaType)
+}
+
+// formatValue renders val the way Athena renders values of athenaType, the
+// inverse of convertValue. nil is NULL.
+func formatValue(athenaType string, val interface{}) (*string, error) {
+	return athenafmt.Format(athenaType, val)
+}
+
+// isDecimalType reports whether athenaType is `decimal` or a parameterized
+// `decimal(p,s)`.
+func isDecimalType(athenaType string) bool {
+	return normalizeType(athenaType) == "decimal"
+}
+
+// parseVarbinary decodes Athena's hex rendering of a varbinary value, which
+// may group bytes with spaces (e.g. "68 65 6c 6c 6f").
+func parseVarbinary(val string) ([]byte, error) {
+	b, err := hex.DecodeString(strings.Join(strings.Fields(val), ""))
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse '%s' as varbinary: %w", val, err)
+	}
+	return b, nil
+}
+
+// parseWithTimeZone parses val with layout followed by a zone, which Athena
+// renders as a region ID ("America/New_York"), an abbreviation ("UTC") or a
+// numeric offset ("+05:30"), optionally separated by a space.
+func parseWithTimeZone(layout, val string) (time.Time, error) {
+	clock, zone := splitZone(val)
+	if zone == "" {
+		return time.Time{}, fmt.Errorf("cannot parse '%s': missing time zone", val)
+	}
+	loc, err := loadZone(zone)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("cannot parse '%s': %w", val, err)
+	}
+	return time.ParseInLocation(layout, clock, loc)
+}
+
+// splitZone splits a trailing zone ID or UTC offset off val.
+func splitZone(val string) (clock, zone string) {
+	if i := strings.LastIndexByte(val, ' '); i >= 0 && i < len(val)-1 {
+		if c := val[i+1]; c == '+' || c == '-' || unicode.IsLetter(rune(c)) {
+			return val[:i], val[i+1:]
+		}
+	}
+	// offsets may also directly follow the clock, as in "03:04:05.123+05:30"
+	if i := strings.LastIndexAny(val, "+-"); i >= 0 && strings.Contains(val[:i], ":") {
+		return val[:i], val[i:]
+	}
+	if strings.HasSuffix(val, "Z") {
+		return val[:len(val)-1], "UTC"
+	}
+	return val, ""
+}
+
+func loadZone(zone string) (*time.Location, error) {
+	if zone[0] == '+' || zone[0] == '-' {
+		for _, layout := range []string{"-07:00", "-0700", "-07"} {
+			if t, err := time.Parse(layout, zone); err == nil {
+				// name the zone after its offset, so it is formatted back
+				// the way Athena renders it
+				_, offset := t.Zone()
+				return time.FixedZone(t.Format("-07:00"), offset), nil
+			}
+		}
+		return nil, fmt.Errorf("invalid time zone offset %s", zone)
+	}
+	if zone == "Z" || zone == "UTC" {
+		return time.UTC, nil
+	}
+
+	if loc, ok := zoneCache.Load(zone); ok {
+		return loc.(*time.Location), nil
+	}
+	loc, err := time.LoadLocation(zone)
+	if err != nil {
+		return nil, err
+	}
+	zoneCache.Store(zone, loc)
+	return loc, nil
+}
+
+// parseIntervalYearToMonth parses an `interval year to month` such as "-1-2"
+// and returns the total number of months.
+func parseIntervalYearToMonth(val string) (int64, error) {
+	sign, rest := int64(1), val
+	if strings.HasPrefix(rest, "-") {
+		sign, rest = -1, rest[1:]
+	}
+	yearStr, monthStr, ok := strings.Cut(rest, "-")
+	if !ok {
+		return 0, fmt.Errorf("cannot parse '%s' as interval year to month", val)
+	}
+	years, err := strconv.ParseInt(yearStr, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("cannot parse '%s' as interval year to month", val)
+	}
+	months, err := strconv.ParseInt(monthStr, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("cannot parse '%s' as interval year to month", val)
+	}
+	return sign * (years*12 + months), nil
+}
+
+// parseIntervalDayToSecond parses an `interval day to second` such as
+// "-2 03:04:05.678".
+func parseIntervalDayToSecond(val string) (time.Duration, error) {
+	sign, rest := time.Duration(1), val
+	if strings.HasPrefix(rest, "-") {
+		sign, rest = -1, rest[1:]
+	}
+	dayStr, clock, ok := strings.Cut(rest, " ")
+	if !ok {
+		return 0, fmt.Errorf("cannot parse '%s' as interval day to second", val)
+	}
+	days, err := strconv.ParseInt(dayStr, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("cannot parse '%s' as interval day to second", val)
+	}
+	parts := strings.Split(clock, ":")
+	if len(parts) != 3 {
+		return 0, fmt.Errorf("cannot parse '%s' as interval day to second", val)
+	}
+	d, err := time.ParseDuration(parts[0] + "h" + parts[1] + "m" + parts[2] + "s")
+	if err != nil {
+		return 0, fmt.Errorf("cannot parse '%s' as interval day to second", val)
+	}
+	return sign * (time.Duration(days)*24*time.Hour + d), nil
+}
+
+var (
+	scanTypeInt64        = reflect.TypeOf(int64(0))
+	scanTypeNullInt64    = reflect.TypeOf(sql.NullInt64{})
+	scanTypeFloat64      = reflect.TypeOf(float64(0))
+	scanTypeNullFloat64  = reflect.TypeOf(sql.NullFloat64{})
+	scanTypeBool         = reflect.TypeOf(false)
+	scanTypeNullBool     = reflect.TypeOf(sql.NullBool{})
+	scanTypeString       = reflect.TypeOf("")
+	scanTypeNullString   = reflect.TypeOf(sql.NullString{})
+	scanTypeTime         = reflect.TypeOf(time.Time{})
+	scanTypeNullTime     = reflect.TypeOf(sql.NullTime{})
+	scanTypeDecimal      = reflect.TypeOf(Decimal{})
+	scanTypeNullDecimal  = reflect.TypeOf(NullDecimal{})
+	scanTypeBytes        = reflect.TypeOf([]byte(nil))
+	scanTypeIP           = reflect.TypeOf(net.IP(nil))
+	scanTypeDuration     = reflect.TypeOf(time.Duration(0))
+	scanTypeNullDuration = reflect.TypeOf((*time.Duration)(nil))
+	scanTypeAny          = reflect.TypeOf((*interface{})(nil)).Elem()
+)
+
+// scanType returns the Go type a column of athenaType is best scanned into.
+// Nullable columns map to the matching sql.Null* type, or to a pointer when
+// database/sql has none.
+func scanType(athenaType string, nullable bool) reflect.Type {
+	pick := func(notNull, null reflect.Type) reflect.Type {
+		if nullable {
+			return null
+		}
+		return notNull
+	}
+
+	switch normalizeType(athenaType) {
+	case "tinyint", "smallint", "integer", "int", "bigint", "interval year to month":
+		return pick(scanTypeInt64, scanTypeNullInt64)
+	case "boolean":
+		return pick(scanTypeBool, scanTypeNullBool)
+	case "float", "real", "double":
+		return pick(scanTypeFloat64, scanTypeNullFloat64)
+	case "decimal":
+		return pick(scanTypeDecimal, scanTypeNullDecimal)
+	case "varchar", "char", "string", "uuid", "row", "array", "map":
+		return pick(scanTypeString, scanTypeNullString)
+	case "varbinary", "json":
+		return scanTypeBytes
+	case "ipaddress":
+		return scanTypeIP
+	case "timestamp", "timestamp with time zone", "date", "time", "time with time zone":
+		return pick(scanTypeTime, scanTypeNullTime)
+	case "interval day to second":
+		return pick(scanTypeDuration, scanTypeNullDuration)
+	default:
+		return scanTypeAny
+	}
+}
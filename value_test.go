@@ -0,0 +1,205 @@
+package athena
+
+import (
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConvertValue_Decimal(t *testing.T) {
+	for _, athenaType := range []string{"decimal", "decimal(38,9)"} {
+		val, err := convertValue(athenaType, aws.String("12345678901234567890.123456789"), nil)
+		require.NoError(t, err, athenaType)
+		assert.Equal(t, "12345678901234567890.123456789", val, athenaType)
+	}
+
+	_, err := convertValue("decimal(10,2)", aws.String("1.2.3"), nil)
+	assert.Error(t, err)
+}
+
+func TestDecimal(t *testing.T) {
+	tests := []struct {
+		in       string
+		unscaled string
+		scale    int32
+		str      string
+		rat      string
+	}{
+		{in: "0.01", unscaled: "1", scale: 2, str: "0.01", rat: "1/100"},
+		{in: "-1001.50", unscaled: "-100150", scale: 2, str: "-1001.50", rat: "-2003/2"},
+		{in: "42", unscaled: "42", scale: 0, str: "42", rat: "42/1"},
+		{in: ".5", unscaled: "5", scale: 1, str: "0.5", rat: "1/2"},
+	}
+	for _, test := range tests {
+		d, err := ParseDecimal(test.in)
+		require.NoError(t, err, test.in)
+		assert.Equal(t, test.unscaled, d.Unscaled().String(), test.in)
+		assert.Equal(t, test.scale, d.Scale(), test.in)
+		assert.Equal(t, test.str, d.String(), test.in)
+		assert.Equal(t, test.rat, d.Rat().String(), test.in)
+	}
+
+	for _, in := range []string{"", "-", "+-1", "1e3", "abc"} {
+		_, err := ParseDecimal(in)
+		assert.Error(t, err, in)
+	}
+
+	assert.Equal(t, "-0.005", NewDecimal(big.NewInt(-5), 3).String())
+}
+
+func TestDecimal_Scan(t *testing.T) {
+	var d Decimal
+	require.NoError(t, d.Scan("10.25"))
+	assert.Equal(t, "10.25", d.String())
+	require.NoError(t, d.Scan(int64(7)))
+	assert.Equal(t, "7", d.String())
+	assert.Error(t, d.Scan(nil))
+
+	var n NullDecimal
+	require.NoError(t, n.Scan(nil))
+	assert.False(t, n.Valid)
+	require.NoError(t, n.Scan([]byte("3.1")))
+	assert.True(t, n.Valid)
+	assert.Equal(t, "3.1", n.Decimal.String())
+}
+
+func TestNormalizeType(t *testing.T) {
+	tests := map[string]string{
+		"varchar(255)":                "varchar",
+		"decimal(38,9)":               "decimal",
+		"timestamp(3)":                "timestamp",
+		"timestamp(6) with time zone": "timestamp with time zone",
+		"TIME(3) WITH TIME ZONE":      "time with time zone",
+		"interval day to second":      "interval day to second",
+		"row(a varchar(3), b int)":    "row",
+	}
+	for in, expected := range tests {
+		assert.Equal(t, expected, normalizeType(in), in)
+	}
+}
+
+func TestConvertValue(t *testing.T) {
+	tests := []struct {
+		athenaType string
+		in         string
+		expected   interface{}
+	}{
+		{athenaType: "char(3)", in: "ab ", expected: "ab "},
+		{athenaType: "varchar(255)", in: "hello", expected: "hello"},
+		{athenaType: "uuid", in: "12151fd2-7586-11e9-8f9e-2a86e4085a59", expected: "12151fd2-7586-11e9-8f9e-2a86e4085a59"},
+		{athenaType: "real", in: "1.5", expected: 1.5},
+		{athenaType: "varbinary", in: "68 65 6c 6c 6f", expected: []byte("hello")},
+		{athenaType: "json", in: `{"a":[1,2]}`, expected: []byte(`{"a":[1,2]}`)},
+		{athenaType: "ipaddress", in: "10.0.0.1", expected: net.ParseIP("10.0.0.1")},
+		{athenaType: "ipaddress", in: "2001:db8::1", expected: net.ParseIP("2001:db8::1")},
+		{athenaType: "timestamp(6)", in: "2024-01-02 03:04:05.123456", expected: time.Date(2024, 1, 2, 3, 4, 5, 123456000, time.UTC)},
+		{athenaType: "time", in: "03:04:05.123", expected: time.Date(0, 1, 1, 3, 4, 5, 123000000, time.UTC)},
+		{athenaType: "time(3) with time zone", in: "03:04:05.123+05:30", expected: time.Date(0, 1, 1, 3, 4, 5, 123000000, time.FixedZone("+05:30", 5*3600+30*60))},
+		{athenaType: "interval year to month", in: "1-2", expected: int64(14)},
+		{athenaType: "interval year to month", in: "-0-3", expected: int64(-3)},
+		{athenaType: "interval day to second", in: "2 03:04:05.500", expected: 2*24*time.Hour + 3*time.Hour + 4*time.Minute + 5500*time.Millisecond},
+		{athenaType: "interval day to second", in: "-0 00:00:01.000", expected: -time.Second},
+		{athenaType: "array(integer)", in: "[1, 2]", expected: "[1, 2]"},
+		{athenaType: "map(varchar, integer)", in: "{a=1}", expected: "{a=1}"},
+	}
+	for _, test := range tests {
+		val, err := convertValue(test.athenaType, &test.in, nil)
+		require.NoError(t, err, test.athenaType)
+		if expectedTime, ok := test.expected.(time.Time); ok {
+			assert.True(t, expectedTime.Equal(val.(time.Time)), "%s: %v", test.athenaType, val)
+			continue
+		}
+		assert.Equal(t, test.expected, val, test.athenaType)
+	}
+
+	for athenaType, in := range map[string]string{
+		"varbinary":              "zz",
+		"ipaddress":              "not-an-ip",
+		"interval year to month": "14",
+		"interval day to second": "03:04:05",
+	} {
+		_, err := convertValue(athenaType, &in, nil)
+		assert.Error(t, err, athenaType)
+	}
+}
+
+func TestConvertValue_TimeZones(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+
+	tests := []struct {
+		athenaType string
+		in         string
+		loc        *time.Location
+		expected   time.Time
+	}{
+		{athenaType: "timestamp with time zone", in: "2024-01-02 03:04:05.123 UTC", expected: time.Date(2024, 1, 2, 3, 4, 5, 123000000, time.UTC)},
+		{athenaType: "timestamp with time zone", in: "2024-01-02 03:04:05.123 America/New_York", expected: time.Date(2024, 1, 2, 3, 4, 5, 123000000, newYork)},
+		{athenaType: "timestamp with time zone", in: "2024-01-02 03:04:05.123 +05:30", expected: time.Date(2024, 1, 1, 21, 34, 5, 123000000, time.UTC)},
+		{athenaType: "timestamp(6) with time zone", in: "2024-01-02 03:04:05.123456-08:00", expected: time.Date(2024, 1, 2, 11, 4, 5, 123456000, time.UTC)},
+		{athenaType: "timestamp(9)", in: "2024-01-02 03:04:05.123456789", expected: time.Date(2024, 1, 2, 3, 4, 5, 123456789, time.UTC)},
+		{athenaType: "timestamp", in: "2024-01-02 03:04:05", loc: newYork, expected: time.Date(2024, 1, 2, 3, 4, 5, 0, newYork)},
+		{athenaType: "date", in: "2024-01-02", loc: newYork, expected: time.Date(2024, 1, 2, 0, 0, 0, 0, newYork)},
+	}
+	for _, test := range tests {
+		val, err := convertValue(test.athenaType, &test.in, test.loc)
+		require.NoError(t, err, test.in)
+		assert.True(t, test.expected.Equal(val.(time.Time)), "%s: %v", test.in, val)
+	}
+
+	in := "2024-01-02 03:04:05 Not/AZone"
+	_, err = convertValue("timestamp with time zone", &in, nil)
+	assert.Error(t, err)
+}
+
+func TestFormatValue_RoundTrip(t *testing.T) {
+	for _, test := range []struct {
+		athenaType string
+		in         string
+	}{
+		{athenaType: "timestamp with time zone", in: "2024-01-02 03:04:05.123 +05:30"},
+		{athenaType: "timestamp with time zone", in: "2024-01-02 03:04:05.123 -08:00"},
+		{athenaType: "timestamp with time zone", in: "2024-01-02 03:04:05.123 America/New_York"},
+		{athenaType: "timestamp with time zone", in: "2024-01-02 03:04:05.123 UTC"},
+		{athenaType: "time with time zone", in: "03:04:05.123 +05:30"},
+		{athenaType: "timestamp", in: "2024-01-02 03:04:05.123"},
+		{athenaType: "timestamp(6)", in: "2024-01-02 03:04:05.123456"},
+		{athenaType: "timestamp(6)", in: "2024-01-02 03:04:05.100000"},
+		{athenaType: "timestamp(9)", in: "2024-01-02 03:04:05.123456789"},
+		{athenaType: "timestamp(0)", in: "2024-01-02 03:04:05"},
+		{athenaType: "timestamp(6) with time zone", in: "2024-01-02 03:04:05.123456 +05:30"},
+		{athenaType: "time(6)", in: "03:04:05.000001"},
+		{athenaType: "date", in: "2024-01-02"},
+		{athenaType: "time", in: "03:04:05.123"},
+		{athenaType: "varbinary", in: "68656c6c6f"},
+		{athenaType: "bigint", in: "-42"},
+		{athenaType: "double", in: "1.5"},
+		{athenaType: "real", in: "0.1"},
+		{athenaType: "boolean", in: "true"},
+		{athenaType: "decimal(10,2)", in: "10.50"},
+		{athenaType: "ipaddress", in: "10.0.0.1"},
+		{athenaType: "interval year to month", in: "1-2"},
+		{athenaType: "interval year to month", in: "-0-3"},
+		{athenaType: "interval day to second", in: "2 03:04:05.500"},
+		{athenaType: "interval day to second", in: "-0 00:00:01.000"},
+	} {
+		val, err := convertValue(test.athenaType, &test.in, nil)
+		require.NoError(t, err, test.in)
+		text, err := formatValue(test.athenaType, val)
+		require.NoError(t, err, test.in)
+		assert.Equal(t, test.in, *text)
+	}
+
+	text, err := formatValue("timestamp with time zone", time.Date(2024, 1, 2, 3, 4, 5, 0, time.FixedZone("", 5*3600+30*60)))
+	require.NoError(t, err)
+	assert.Equal(t, "2024-01-02 03:04:05 +05:30", *text)
+
+	text, err = formatValue("varchar", nil)
+	assert.NoError(t, err)
+	assert.Nil(t, text)
+}